@@ -0,0 +1,105 @@
+// Package otelweb 为requests创建的gin.Engine接入OpenTelemetry链路追踪
+package otelweb
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/qweb/requests"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const (
+	TracerName = "github.com/rolandhe/qweb/otelweb"
+)
+
+// Config 链路追踪的配置，未设置的字段使用otel的全局TracerProvider和W3C traceparent/tracestate、baggage传播
+type Config struct {
+	TracerProvider trace.TracerProvider
+	Propagators    propagation.TextMapPropagator
+}
+
+func (c Config) tracer() trace.Tracer {
+	tp := c.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(TracerName)
+}
+
+func (c Config) propagators() propagation.TextMapPropagator {
+	if c.Propagators != nil {
+		return c.Propagators
+	}
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Install 把链路追踪的中间件和阶段钩子设置到opts，opts为nil时新建，返回值直接传给requests.NewEngineWithOptions
+func Install(opts *requests.EngineOptions, cfg Config) *requests.EngineOptions {
+	if opts == nil {
+		opts = &requests.EngineOptions{}
+	}
+	opts.Middlewares = append([]gin.HandlerFunc{Middleware(cfg)}, opts.Middlewares...)
+	opts.StageFunc = StageFunc(cfg)
+	return opts
+}
+
+// Middleware 从请求头中提取traceparent/tracestate，以路由模板命名创建server span，
+// 并把span的trace id设置为请求的commons.TraceId，响应头中返回当前span的traceparent
+func Middleware(cfg Config) gin.HandlerFunc {
+	tracer := cfg.tracer()
+	propagators := cfg.propagators()
+	return func(gctx *gin.Context) {
+		ctx := propagators.Extract(gctx.Request.Context(), propagation.HeaderCarrier(gctx.Request.Header))
+		route := gctx.FullPath()
+		if route == "" {
+			route = requests.UnmatchedRoute
+		}
+		method := gctx.Request.Method
+		ctx, span := tracer.Start(ctx, method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", gctx.Request.URL.Path),
+				attribute.String("client.address", gctx.ClientIP()),
+			))
+		defer span.End()
+
+		gctx.Request = gctx.Request.WithContext(ctx)
+		requests.SetTraceId(gctx, span.SpanContext().TraceID().String())
+		propagators.Inject(ctx, propagation.HeaderCarrier(gctx.Writer.Header()))
+
+		gctx.Next()
+
+		status := gctx.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		kind, code := requests.RequestOutcome(gctx)
+		span.SetAttributes(attribute.String("qweb.outcome", kind.Outcome()), attribute.Int("qweb.code", code))
+		for _, e := range gctx.Errors {
+			span.RecordError(e.Err)
+		}
+		if status >= http.StatusInternalServerError || kind == requests.ResultPanic {
+			span.SetStatus(codes.Error, fmt.Sprintf("%s, code=%d", kind.Outcome(), code))
+		}
+	}
+}
+
+// StageFunc 为鉴权、业务等阶段创建子span，阶段内gctx.Request的context替换为子span的context，
+// 业务函数通过requests.RequestContext可以继续创建下级span
+func StageFunc(cfg Config) requests.StageFunc {
+	tracer := cfg.tracer()
+	return func(gctx *gin.Context, stage string) func() {
+		parent := gctx.Request.Context()
+		ctx, span := tracer.Start(parent, stage)
+		gctx.Request = gctx.Request.WithContext(ctx)
+		return func() {
+			span.End()
+			gctx.Request = gctx.Request.WithContext(parent)
+		}
+	}
+}
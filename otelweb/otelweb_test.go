@@ -0,0 +1,162 @@
+package otelweb
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"github.com/rolandhe/go-base/logger"
+	"github.com/rolandhe/qweb/requests"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+type itemReq struct {
+	Id int64 `uri:"id"`
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	cfg := Config{TracerProvider: tp}
+
+	var bizTraceId string
+	e := requests.NewEngineWithOptions(gin.TestMode, Install(nil, cfg))
+	requests.Get(e.Group("/public"), &requests.RequestDesc[itemReq, *commons.Result[int64]]{
+		RelativePath: "/items/:id",
+		AuthMode:     requests.AuthModeNone,
+		BizCoreFunc: func(ctx *commons.BaseContext, req *itemReq) *commons.Result[int64] {
+			bizTraceId = ctx.Get(commons.TraceId)
+			_, span := tp.Tracer("biz").Start(requests.RequestContext(ctx), "query")
+			span.End()
+			return commons.OkResult(req.Id)
+		},
+	})
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/public/items/7", nil)
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if bizTraceId != traceId {
+		t.Errorf("commons.TraceId %q, want %q", bizTraceId, traceId)
+	}
+
+	spans := exporter.GetSpans()
+	byName := map[string]tracetest.SpanStub{}
+	for _, s := range spans {
+		if s.SpanContext.TraceID().String() != traceId {
+			t.Errorf("span %s has trace id %s", s.Name, s.SpanContext.TraceID())
+		}
+		byName[s.Name] = s
+	}
+	server, ok := byName["GET /public/items/:id"]
+	if !ok {
+		t.Fatalf("server span missing, got %v", spans)
+	}
+	if server.SpanKind != trace.SpanKindServer || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span kind %v parent %s", server.SpanKind, server.Parent.SpanID())
+	}
+	for _, name := range []string{requests.StageAuth, requests.StageBiz} {
+		if byName[name].Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the server span", name)
+		}
+	}
+	if byName["query"].Parent.SpanID() != byName[requests.StageBiz].SpanContext.SpanID() {
+		t.Error("biz inner span is not a child of the biz span")
+	}
+	if got := w.Header().Get("traceparent"); got != "00-"+traceId+"-"+server.SpanContext.SpanID().String()+"-01" {
+		t.Errorf("response traceparent %q", got)
+	}
+}
+
+func TestTracingWithoutParent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var bizTraceId string
+	e := requests.NewEngineWithOptions(gin.TestMode, Install(nil, Config{TracerProvider: tp}))
+	requests.Get(e.Group("/public"), &requests.RequestDesc[itemReq, *commons.Result[int64]]{
+		RelativePath: "/items/:id",
+		AuthMode:     requests.AuthModeNone,
+		BizCoreFunc: func(ctx *commons.BaseContext, req *itemReq) *commons.Result[int64] {
+			bizTraceId = ctx.Get(commons.TraceId)
+			return commons.OkResult(req.Id)
+		},
+	})
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public/items/7", nil))
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	if bizTraceId != spans[2].SpanContext.TraceID().String() {
+		t.Errorf("commons.TraceId %q, want %q", bizTraceId, spans[2].SpanContext.TraceID())
+	}
+	if spans[3].Name != "GET "+requests.UnmatchedRoute {
+		t.Errorf("unmatched span name %q", spans[3].Name)
+	}
+}
+
+func TestTracingPanic(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	e := requests.NewEngineWithOptions(gin.TestMode, Install(nil, Config{TracerProvider: tp}))
+	for _, timeout := range []time.Duration{0, time.Second} {
+		requests.Get(e.Group("/public"), &requests.RequestDesc[itemReq, *commons.Result[int64]]{
+			RelativePath: fmt.Sprintf("/panic/%d/:id", timeout),
+			AuthMode:     requests.AuthModeNone,
+			Timeout:      timeout,
+			BizCoreFunc: func(ctx *commons.BaseContext, req *itemReq) *commons.Result[int64] {
+				panic("boom")
+			},
+		})
+	}
+
+	for _, timeout := range []time.Duration{0, time.Second} {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/public/panic/%d/7", timeout), nil))
+		if w.Code != http.StatusOK && w.Code != http.StatusInternalServerError {
+			t.Fatalf("status %d", w.Code)
+		}
+
+		byName := map[string]tracetest.SpanStub{}
+		for _, s := range exporter.GetSpans() {
+			byName[s.Name] = s
+		}
+		server, ok := byName[fmt.Sprintf("GET /public/panic/%d/:id", timeout)]
+		if !ok {
+			t.Fatalf("timeout %v: server span missing", timeout)
+		}
+		if server.Status.Code != codes.Error {
+			t.Errorf("timeout %v: server span status %v", timeout, server.Status)
+		}
+		biz, ok := byName[requests.StageBiz]
+		if !ok {
+			t.Fatalf("timeout %v: biz span not ended after panic", timeout)
+		}
+		if biz.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("timeout %v: biz span is not a child of the server span", timeout)
+		}
+	}
+}
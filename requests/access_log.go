@@ -0,0 +1,84 @@
+package requests
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"github.com/rolandhe/go-base/logger"
+	"time"
+)
+
+// AccessLogRecord 每个请求一条的结构化访问日志，耗时单位为毫秒，保留小数
+type AccessLogRecord struct {
+	Time       time.Time         `json:"time"`
+	TraceId    string            `json:"traceId"`
+	Route      string            `json:"route"`
+	Path       string            `json:"path"`
+	Method     string            `json:"method"`
+	Status     int               `json:"status"`
+	Outcome    string            `json:"outcome"`
+	Code       int               `json:"code"`
+	Uid        int64             `json:"uid"`
+	Platform   string            `json:"platform"`
+	LatencyMs  float64           `json:"latencyMs"`
+	AuthMs     float64           `json:"authMs,omitempty"`
+	BizMs      float64           `json:"bizMs,omitempty"`
+	BytesIn    int64             `json:"bytesIn"`
+	BytesOut   int64             `json:"bytesOut"`
+	ClientIP   string            `json:"clientIp"`
+	UserAgent  string            `json:"userAgent"`
+	KeyHeaders map[string]string `json:"keyHeaders,omitempty"`
+}
+
+// AccessLogSink 输出访问日志，在请求处理完成后调用
+type AccessLogSink func(ctx *commons.BaseContext, record *AccessLogRecord)
+
+// AccessLogFunc 默认为nil，不输出访问日志，可以设置为LoggerAccessLog或者自定义的sink
+var AccessLogFunc AccessLogSink
+
+// DisableRequestLog 为true时不再输出"enter ..."和"exit ..."两行请求日志，一般在开启访问日志后使用
+var DisableRequestLog = false
+
+// LoggerAccessLog 把访问日志序列化为json，通过logger输出
+func LoggerAccessLog(ctx *commons.BaseContext, record *AccessLogRecord) {
+	j, _ := json.Marshal(record)
+	logger.WithBaseContextInfof(ctx)("access %s", string(j))
+}
+
+// doAccessLog 由monitorHandler在请求结束后调用，复用其计算的路由、耗时和结果
+func doAccessLog(gctx *gin.Context, route string, timing *requestTiming, kind ResultKind, code int) {
+	sink := getEngineOptions(gctx).accessLogFunc()
+	if sink == nil {
+		return
+	}
+	ctx := genBaseContext(gctx)
+	record := &AccessLogRecord{
+		Time:       timing.start,
+		TraceId:    ctx.Get(commons.TraceId),
+		Route:      route,
+		Path:       gctx.Request.URL.Path,
+		Method:     gctx.Request.Method,
+		Status:     gctx.Writer.Status(),
+		Outcome:    kind.Outcome(),
+		Code:       code,
+		Uid:        ctx.QuickInfo().Uid,
+		Platform:   ctx.Get(commons.Platform),
+		LatencyMs:  toMillis(time.Since(timing.start)),
+		BytesIn:    requestSize(gctx),
+		BytesOut:   responseSize(gctx),
+		ClientIP:   gctx.ClientIP(),
+		UserAgent:  gctx.Request.UserAgent(),
+		KeyHeaders: keyHeaderValues(gctx),
+	}
+	if cost, ok := timing.authCost(); ok {
+		record.AuthMs = toMillis(cost)
+	}
+	if cost, ok := timing.bizCost(); ok {
+		record.BizMs = toMillis(cost)
+	}
+	sink(ctx, record)
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
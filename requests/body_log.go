@@ -0,0 +1,140 @@
+package requests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLogRequestBytes 和 MaxLogResponseBytes 日志中请求体和响应的最大字节数，超过的部分截断，小于0表示不限制
+var (
+	MaxLogRequestBytes  = 4096
+	MaxLogResponseBytes = 4096
+)
+
+// maxRedactBytes 超过该大小的内容不做脱敏解析，日志中只记录大小
+const maxRedactBytes = 1 << 20
+
+// BinaryContentTypes 请求体是这些类型时日志中只记录类型和大小，按前缀匹配
+var BinaryContentTypes = []string{
+	"multipart/",
+	"application/octet-stream",
+	"application/x-protobuf",
+	"application/protobuf",
+	"application/x-msgpack",
+	"application/msgpack",
+	"application/zip",
+	"application/pdf",
+	"image/",
+	"audio/",
+	"video/",
+}
+
+// bodyLogConf 请求体或响应在日志中的输出方式
+type bodyLogConf struct {
+	plan     *redactPlan
+	maskKeys []string
+	maxBytes int
+}
+
+// logLimit RequestDesc上设置了限制时优先使用，否则使用engine的配置
+func logLimit(descLimit int, engineLimit int) int {
+	if descLimit != 0 {
+		return descLimit
+	}
+	return engineLimit
+}
+
+// format 先脱敏再截断，保证截断后的内容中不会留下未脱敏的字段
+func (c *bodyLogConf) format(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if !utf8.Valid(data) {
+		return fmt.Sprintf("<binary %d bytes>", len(data))
+	}
+	if len(data) > maxRedactBytes {
+		return fmt.Sprintf("<omitted %d bytes>", len(data))
+	}
+	return truncateLog(redactJSON(data, c.plan, c.maskKeys), c.maxBytes, len(data))
+}
+
+// formatResult 序列化返回结果用于日志，超过maxBytes时不再解析脱敏，只记录大小，避免每个错误结果都完整解析一遍大的响应
+func (c *bodyLogConf) formatResult(rt any) string {
+	data, err := json.Marshal(rt)
+	if err != nil {
+		return ""
+	}
+	limit := c.maxBytes
+	if limit < 0 || limit > maxRedactBytes {
+		limit = maxRedactBytes
+	}
+	if len(data) > limit {
+		return fmt.Sprintf("<omitted %d bytes>", len(data))
+	}
+	return c.format(data)
+}
+
+// requestBody 获取日志中输出的请求体，二进制只输出类型和大小。表单在绑定时已经解析，
+// 按解析后的字段输出，multipart只输出文件之外的字段
+func (c *bodyLogConf) requestBody(gctx *gin.Context) string {
+	ct := gctx.ContentType()
+	switch {
+	case ct == binding.MIMEPOSTForm:
+		return c.formBody(gctx.Request.PostForm)
+	case ct == binding.MIMEMultipartPOSTForm && gctx.Request.MultipartForm != nil:
+		if fields := c.formBody(gctx.Request.MultipartForm.Value); fields != "" {
+			return binarySize(gctx, ct) + " " + fields
+		}
+		return binarySize(gctx, ct)
+	case isBinaryContent(ct):
+		return binarySize(gctx, ct)
+	}
+	bodyBytes, exists := gctx.Get(gin.BodyBytesKey)
+	if !exists {
+		return ""
+	}
+	return c.format(bodyBytes.([]byte))
+}
+
+func (c *bodyLogConf) formBody(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+	q := values.Encode()
+	redacted, _ := redactQuery(q, c.plan, c.maskKeys)
+	return truncateLog(redacted, c.maxBytes, len(q))
+}
+
+func binarySize(gctx *gin.Context, contentType string) string {
+	// chunked请求的ContentLength为-1
+	if gctx.Request.ContentLength < 0 {
+		return fmt.Sprintf("<%s unknown bytes>", contentType)
+	}
+	return fmt.Sprintf("<%s %d bytes>", contentType, gctx.Request.ContentLength)
+}
+
+func isBinaryContent(contentType string) bool {
+	for _, prefix := range BinaryContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateLog 超过maxBytes时在utf8字符边界截断，并追加原始大小
+func truncateLog(s string, maxBytes int, originalSize int) string {
+	if maxBytes < 0 || len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...(truncated, %d bytes)", s[:cut], originalSize)
+}
@@ -0,0 +1,139 @@
+package requests
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"strings"
+)
+
+const (
+	clientInfoName = "client_info_qweb"
+)
+
+// ClientField 客户端请求头对应的ClientInfo字段
+type ClientField int
+
+const (
+	// ClientFieldExtra 没有对应的字段，放入ClientInfo.Extra
+	ClientFieldExtra ClientField = iota
+	ClientFieldDeviceId
+	ClientFieldHardware
+	ClientFieldOs
+	ClientFieldOsVersion
+	ClientFieldResolution
+	ClientFieldAppKey
+	ClientFieldAppVersion
+)
+
+// ClientHeader 客户端通过请求头上报的设备和应用信息，同时用于跨域允许的请求头、请求日志和ClientInfo
+type ClientHeader struct {
+	Name  string
+	Field ClientField
+	// Log 为true时记录在请求日志的keyHeader和访问日志的keyHeaders中
+	Log bool
+}
+
+// ClientHeaders 默认的客户端请求头，多个请求头对应同一个字段时取第一个有值的
+var ClientHeaders = []ClientHeader{
+	{Name: "device-id", Field: ClientFieldDeviceId, Log: true},
+	{Name: "hardware", Field: ClientFieldHardware, Log: true},
+	{Name: "os", Field: ClientFieldOs, Log: true},
+	{Name: "os-version", Field: ClientFieldOsVersion, Log: true},
+	{Name: "resolution", Field: ClientFieldResolution},
+	{Name: "app-key", Field: ClientFieldAppKey},
+	{Name: "app-version", Field: ClientFieldAppVersion, Log: true},
+	{Name: "app_vsn", Field: ClientFieldAppVersion},
+}
+
+// ClientInfo 客户端的设备和应用信息，业务代码通过GetClientInfo获取
+type ClientInfo struct {
+	DeviceId   string
+	Hardware   string
+	Os         string
+	OsVersion  string
+	Resolution string
+	AppKey     string
+	AppVersion string
+	// Extra ClientFieldExtra类型的请求头，key是请求头名称
+	Extra map[string]string
+}
+
+// GetClientInfo 获取请求的客户端信息，不是通过本包创建的BaseContext返回空的ClientInfo
+func GetClientInfo(ctx *commons.BaseContext) *ClientInfo {
+	if info, ok := ctx.GetExtendValue(clientInfoName).(*ClientInfo); ok {
+		return info
+	}
+	return &ClientInfo{}
+}
+
+func newClientInfo(gctx *gin.Context, headers []ClientHeader) *ClientInfo {
+	info := &ClientInfo{}
+	for _, h := range headers {
+		v := getHeader(gctx, h.Name)
+		if v == "" {
+			continue
+		}
+		if h.Field == ClientFieldExtra {
+			if info.Extra == nil {
+				info.Extra = map[string]string{}
+			}
+			info.Extra[h.Name] = v
+			continue
+		}
+		if p := info.field(h.Field); p != nil && *p == "" {
+			*p = v
+		}
+	}
+	return info
+}
+
+func (c *ClientInfo) field(f ClientField) *string {
+	switch f {
+	case ClientFieldDeviceId:
+		return &c.DeviceId
+	case ClientFieldHardware:
+		return &c.Hardware
+	case ClientFieldOs:
+		return &c.Os
+	case ClientFieldOsVersion:
+		return &c.OsVersion
+	case ClientFieldResolution:
+		return &c.Resolution
+	case ClientFieldAppKey:
+		return &c.AppKey
+	case ClientFieldAppVersion:
+		return &c.AppVersion
+	}
+	return nil
+}
+
+// keyHeaderValues 需要记录日志的客户端请求头
+func keyHeaderValues(gctx *gin.Context) map[string]string {
+	km := map[string]string{}
+	for _, h := range getEngineOptions(gctx).clientHeaders() {
+		if h.Log {
+			km[h.Name] = getHeader(gctx, h.Name)
+		}
+	}
+	return km
+}
+
+// corsAllowHeaders 跨域允许的请求头，在AllowHeaders之后追加客户端请求头
+func corsAllowHeaders(opts *EngineOptions) []string {
+	allow := append([]string{}, opts.allowHeaders()...)
+	for _, h := range opts.clientHeaders() {
+		if !containsFold(allow, h.Name) {
+			allow = append(allow, h.Name)
+		}
+	}
+	return allow
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package requests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	producesName = "produces_qweb"
+)
+
+// RenderFunc 按某种格式把结果写入响应，obj不支持该格式时返回false，此时退回json
+type RenderFunc func(gctx *gin.Context, code int, obj any) bool
+
+// bindings 请求的content type到参数绑定方式的映射，通过RegisterBinding扩展
+var bindings = map[string]binding.Binding{
+	binding.MIMEJSON:              binding.JSON,
+	binding.MIMEPOSTForm:          binding.Form,
+	binding.MIMEMultipartPOSTForm: binding.FormMultipart,
+	binding.MIMEPROTOBUF:          binding.ProtoBuf,
+}
+
+// renders 响应的content type到输出方式的映射，renderOffers是协商Accept时的候选顺序，第一个是默认格式
+var renders = map[string]RenderFunc{
+	binding.MIMEJSON:     renderJSON,
+	binding.MIMEPROTOBUF: renderProtoBuf,
+}
+
+var renderOffers = []string{
+	binding.MIMEJSON,
+	binding.MIMEPROTOBUF,
+}
+
+// RegisterBinding 注册content type对应的参数绑定方式，需要在启动服务之前调用
+func RegisterBinding(contentType string, b binding.Binding) {
+	bindings[contentType] = b
+}
+
+// RegisterRender 注册content type对应的响应输出方式，需要在启动服务之前调用
+func RegisterRender(contentType string, r RenderFunc) {
+	if _, ok := renders[contentType]; !ok {
+		renderOffers = append(renderOffers, contentType)
+	}
+	renders[contentType] = r
+}
+
+// chooseBindFunc 根据http method选择参数的绑定方式，GET/DELETE/HEAD从query string绑定，
+// 其他从body绑定，body的格式优先使用consumes，否则按请求的Content-Type，无法识别时按json处理。
+// query或body绑定之后再写入uri和header tag声明的路径参数和请求头，最后对合并后的结果统一校验。
+// 同时返回query或body字段名使用的tag，form或json，用于校验错误中的字段名
+func chooseBindFunc(gctx *gin.Context, consumes string, pt *paramTags) (func(obj any) error, string) {
+	var decode func(obj any) error
+	bodyTag := "json"
+	switch gctx.Request.Method {
+	// GET方法支持直接的query string，也支持form data
+	case http.MethodGet, http.MethodDelete, http.MethodHead:
+		decode = func(obj any) error {
+			return binding.MapFormWithTag(obj, gctx.Request.URL.Query(), "form")
+		}
+		bodyTag = "form"
+	default:
+		contentType := consumes
+		if contentType == "" {
+			contentType = gctx.ContentType()
+		}
+		b, ok := bindings[contentType]
+		if !ok {
+			b = binding.JSON
+		}
+		decode = decodeFunc(gctx, b)
+		if b == binding.Form || b == binding.FormMultipart {
+			bodyTag = "form"
+		}
+	}
+	return func(obj any) error {
+		if err := decode(obj); err != nil {
+			return err
+		}
+		if err := bindPathAndHeader(gctx, pt, obj); err != nil {
+			return err
+		}
+		return validateStruct(obj)
+	}, bodyTag
+}
+
+// decodeFunc 返回只解析不校验的绑定函数，json之外的gin绑定在解析之后会校验，其校验结果不是最终结果，忽略
+func decodeFunc(gctx *gin.Context, b binding.Binding) func(obj any) error {
+	if b == binding.JSON {
+		return func(obj any) error {
+			body, err := cachedBody(gctx)
+			if err != nil {
+				return err
+			}
+			return decodeJSON(body, obj)
+		}
+	}
+	var bind func(obj any) error
+	// body类的绑定缓存请求体，打印日志时使用
+	if bb, ok := b.(binding.BindingBody); ok {
+		bind = func(obj any) error {
+			return gctx.ShouldBindBodyWith(obj, bb)
+		}
+	} else {
+		bind = func(obj any) error {
+			return gctx.ShouldBindWith(obj, b)
+		}
+	}
+	return func(obj any) error {
+		if err := bind(obj); err != nil && !isValidationError(err) {
+			return err
+		}
+		return nil
+	}
+}
+
+// cachedBody 读取请求体并缓存在gin.BodyBytesKey中，与ShouldBindBodyWith共用，打印日志时使用
+func cachedBody(gctx *gin.Context) ([]byte, error) {
+	if cb, ok := gctx.Get(gin.BodyBytesKey); ok {
+		if body, ok := cb.([]byte); ok {
+			return body, nil
+		}
+	}
+	var body []byte
+	if gctx.Request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(gctx.Request.Body); err != nil {
+			return nil, err
+		}
+	}
+	gctx.Set(gin.BodyBytesKey, body)
+	return body, nil
+}
+
+// decodeJSON 与binding.JSON的解析方式一致，但不做校验
+func decodeJSON(body []byte, obj any) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if binding.EnableDecoderUseNumber {
+		dec.UseNumber()
+	}
+	if binding.EnableDecoderDisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(obj)
+}
+
+func isValidationError(err error) bool {
+	var errs validator.ValidationErrors
+	var sliceErrs binding.SliceValidationError
+	return errors.As(err, &errs) || errors.As(err, &sliceErrs)
+}
+
+func validateStruct(obj any) error {
+	if binding.Validator == nil {
+		return nil
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// paramTags 请求类型中声明的uri和header tag名称，只有声明过的名称才会从路径参数和请求头中取值，
+// 避免没有tag的字段按字段名匹配到同名的请求头
+type paramTags struct {
+	uri    []string
+	header []string
+}
+
+func newParamTags(t reflect.Type) *paramTags {
+	pt := &paramTags{}
+	collectTagNames(t, "uri", &pt.uri, map[reflect.Type]bool{})
+	collectTagNames(t, "header", &pt.header, map[reflect.Type]bool{})
+	return pt
+}
+
+func collectTagNames(t reflect.Type, tag string, names *[]string, visited map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visited[t] {
+		return
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			*names = append(*names, name)
+		}
+		collectTagNames(f.Type, tag, names, visited)
+	}
+}
+
+// bindPathAndHeader 把路径参数和请求头写入obj，不做校验，在query或body绑定之后执行，
+// 因此query或body中存在同一字段时以路径参数和请求头为准，避免客户端替换路径中的资源id
+func bindPathAndHeader(gctx *gin.Context, pt *paramTags, obj any) error {
+	if len(pt.uri) > 0 {
+		m := map[string][]string{}
+		for _, name := range pt.uri {
+			if v, ok := gctx.Params.Get(name); ok {
+				m[name] = []string{v}
+			}
+		}
+		if err := binding.MapFormWithTag(obj, m, "uri"); err != nil {
+			return err
+		}
+	}
+	if len(pt.header) > 0 {
+		m := map[string][]string{}
+		for _, name := range pt.header {
+			if v := getHeader(gctx, name); v != "" {
+				m[name] = []string{v}
+			}
+		}
+		if err := binding.MapFormWithTag(obj, m, "header"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderResult 输出结果，格式优先使用produces，否则按请求的Accept协商，默认json
+func renderResult(gctx *gin.Context, produces string, code int, rt any) {
+	contentType := produces
+	if contentType == "" {
+		contentType = gctx.NegotiateFormat(renderOffers...)
+	}
+	if r, ok := renders[contentType]; ok && r(gctx, code, rt) {
+		return
+	}
+	renderJSON(gctx, code, rt)
+}
+
+func renderJSON(gctx *gin.Context, code int, obj any) bool {
+	gctx.JSON(code, obj)
+	return true
+}
+
+func renderProtoBuf(gctx *gin.Context, code int, obj any) bool {
+	m, ok := obj.(proto.Message)
+	if !ok {
+		return false
+	}
+	gctx.ProtoBuf(code, m)
+	return true
+}
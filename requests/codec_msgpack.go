@@ -0,0 +1,21 @@
+//go:build !nomsgpack
+
+package requests
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+func init() {
+	RegisterBinding(binding.MIMEMSGPACK, binding.MsgPack)
+	RegisterBinding(binding.MIMEMSGPACK2, binding.MsgPack)
+	RegisterRender(binding.MIMEMSGPACK, renderMsgPack)
+	RegisterRender(binding.MIMEMSGPACK2, renderMsgPack)
+}
+
+func renderMsgPack(gctx *gin.Context, code int, obj any) bool {
+	gctx.Render(code, render.MsgPack{Data: obj})
+	return true
+}
@@ -3,20 +3,132 @@ package requests
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/rolandhe/go-base/commons"
 	"github.com/rolandhe/go-base/logger"
-	"strings"
+	"strconv"
 )
 
 const (
 	baseContextName = "base_context_qweb"
 )
 
+// 框架自身错误使用的错误码，未登录和参数错误沿用commons中的定义
+const (
+	NotLoginCode    = commons.UnAuthorized
+	ForbiddenCode   = 5403
+	RateLimitedCode = 5429
+	ArgsInvalidCode = commons.BadRequest
+	InternalCode    = commons.CommonErr
+	TimeoutCode     = 5504
+	CanceledCode    = 5499
+)
+
+// 框架自身产生的错误，都是*commons.StdError，返回给调用方时转换为commons.Result。
+// 应用可以在启动时替换为自己的错误码和信息
 var (
-	NotLoginError = errors.New("not login")
+	NotLoginError    = commons.NewError(NotLoginCode, "not login")
+	ForbiddenError   = commons.NewError(ForbiddenCode, "forbidden")
+	RateLimitedError = commons.NewError(RateLimitedCode, "too many requests")
+	ArgsInvalidError = commons.NewError(ArgsInvalidCode, "args invalid")
+	InternalError    = commons.NewError(InternalCode, "internal server error")
+	TimeoutError     = commons.NewError(TimeoutCode, "request timeout")
+	CanceledError    = commons.NewError(CanceledCode, "request canceled")
 )
 
+// TextArgsInvalidError ValidationErrorText模式下的参数错误，与原来的返回一样使用commons.CommonErr，保持老客户端兼容。
+// ValidationErrorStructured模式使用ArgsInvalidError
+var TextArgsInvalidError = commons.NewError(commons.CommonErr, "args invalid")
+
+// argsInvalidError 校验错误格式对应的参数错误
+func argsInvalidError(mode ValidationErrorMode) error {
+	if mode == ValidationErrorStructured {
+		return ArgsInvalidError
+	}
+	return TextArgsInvalidError
+}
+
+// FrameworkError 返回结果类别对应的框架错误，ResultOK和ResultBizError没有对应的错误，返回nil
+func FrameworkError(kind ResultKind) error {
+	switch kind {
+	case ResultNotLogin:
+		return NotLoginError
+	case ResultForbidden:
+		return ForbiddenError
+	case ResultRateLimited:
+		return RateLimitedError
+	case ResultArgsInvalid:
+		return ArgsInvalidError
+	case ResultPanic:
+		return InternalError
+	case ResultTimeout:
+		return TimeoutError
+	case ResultCanceled:
+		return CanceledError
+	}
+	return nil
+}
+
+// frameworkErrorResult 钩子返回的err是commons.StdError时按其错误码返回，否则返回kind对应的框架错误
+func frameworkErrorResult(kind ResultKind, err error) *commons.Result[*commons.Void] {
+	var stdErr *commons.StdError
+	if err != nil && errors.As(err, &stdErr) {
+		return commons.QuickFromError(err)
+	}
+	return commons.QuickFromError(FrameworkError(kind))
+}
+
+// hookErrorKind 鉴权钩子返回的err不是commons.StdError时(例如用户服务超时)属于内部错误，
+// 不能按kind当作未登录或无权限处理，否则客户端会在依赖故障时退出登录
+func hookErrorKind(kind ResultKind, err error) ResultKind {
+	var stdErr *commons.StdError
+	if err != nil && !errors.As(err, &stdErr) {
+		return ResultPanic
+	}
+	return kind
+}
+
+// bizErrorResult 业务函数返回的err是commons.StdError时按其错误码返回，否则返回InternalError
+func bizErrorResult(err error) *commons.Result[*commons.Void] {
+	return frameworkErrorResult(ResultPanic, err)
+}
+
+// errorCode 获取框架错误的错误码
+func errorCode(err error) int {
+	var stdErr *commons.StdError
+	if errors.As(err, &stdErr) {
+		return stdErr.Code
+	}
+	return InternalCode
+}
+
+// RoleValues 角色名称到commons.UserInfo.Roles中角色位的映射，RequestDesc.AllowRoles中的值先按这里查找，
+// 查不到时按数字解析
+var RoleValues = map[string]int64{
+	"admin": commons.AdminRole,
+	"user":  commons.UserRole,
+}
+
+// RoleCheckFunc 校验当前用户是否满足路由声明的AllowRoles和AllowProducts，返回error表示拒绝访问。
+// 角色和产品按请求所属engine的RoleValues和ProductFunc解析
+var RoleCheckFunc = func(ctx *commons.BaseContext, allowRoles []string, allowProducts []int, info *commons.QuickInfo) error {
+	opts := engineOptionsOf(ctx)
+	if len(allowRoles) > 0 && !hasAnyRole(allowRoles, opts.roleValues(), info) {
+		return ForbiddenError
+	}
+	if len(allowProducts) > 0 {
+		productFunc := opts.productFunc()
+		if productFunc == nil || !hasAnyProduct(allowProducts, productFunc(ctx, info)) {
+			return ForbiddenError
+		}
+	}
+	return nil
+}
+
+// ProductFunc 获取当前用户所属的产品，用于校验RequestDesc.AllowProducts。
+// 必须根据登录钩子校验过的用户信息获取，不能使用客户端上报的platform等请求头，默认为nil，此时使用了AllowProducts的路由拒绝访问
+var ProductFunc func(ctx *commons.BaseContext, info *commons.QuickInfo) int
+
+// 以下包级钩子是所有engine的默认值，可以通过EngineOptions按engine覆盖
 var ApiUserInfoCheckFunc = func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
 	panic("请设置ApiUserInfoCheckFunc")
 }
@@ -43,6 +155,35 @@ var RateLimiterFunc = func(ctx *commons.BaseContext, uPath string) error {
 	return nil
 }
 
+func hasAnyRole(allowRoles []string, roleValues map[string]int64, info *commons.QuickInfo) bool {
+	if info.Uid == 0 {
+		return false
+	}
+	for _, name := range allowRoles {
+		role, ok := roleValues[name]
+		if !ok {
+			v, err := strconv.ParseInt(name, 10, 64)
+			if err != nil {
+				continue
+			}
+			role = v
+		}
+		if info.IsRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyProduct(allowProducts []int, product int) bool {
+	for _, p := range allowProducts {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
 func genBaseContext(gctx *gin.Context) *commons.BaseContext {
 	v, exists := gctx.Get(baseContextName)
 	if exists {
@@ -50,13 +191,31 @@ func genBaseContext(gctx *gin.Context) *commons.BaseContext {
 	}
 
 	baseContext := commons.NewBaseContext()
+	opts := getEngineOptions(gctx)
+	reqCtx := &requestContextHolder{gctx: gctx}
+	clientInfo := newClientInfo(gctx, opts.clientHeaders())
+	// 不能在闭包中使用gctx读取请求头，超时后业务函数仍在执行，而gctx已经被gin复用给其他请求
+	header := gctx.Request.Header
 	baseContext.RegisterKvExtendFunc(func(key string) any {
-		return gctx.GetHeader(key)
+		switch key {
+		case requestContextName:
+			return reqCtx.get()
+		case clientInfoName:
+			return clientInfo
+		case engineOptionsName:
+			return opts
+		}
+		return header.Get(key)
 	}, commons.KvExtendRegisterOverride)
+	gctx.Set(requestContextName, reqCtx)
 
-	tid := getHeader(gctx, commons.TraceId)
+	tid := gctx.GetString(traceIdName)
+	var invalid []string
 	if tid == "" {
-		tid = newTraceId()
+		tid, invalid = inboundTraceId(gctx, opts.traceIdHeaders())
+	}
+	if tid == "" {
+		tid = opts.traceIdGenerator()()
 	}
 	baseContext.Put(commons.TraceId, tid)
 	baseContext.Put(commons.Profile, getHeader(gctx, commons.Profile))
@@ -69,14 +228,12 @@ func genBaseContext(gctx *gin.Context) *commons.BaseContext {
 	}
 
 	gctx.Set(baseContextName, baseContext)
+	if len(invalid) > 0 {
+		logger.WithBaseContextInfof(baseContext)("drop invalid trace id from headers: %v", invalid)
+	}
 	return baseContext
 }
 
-func newTraceId() string {
-	raw := uuid.NewString()
-	return strings.ReplaceAll(raw, "-", "") + "-cr"
-}
-
 func getToken(gctx *gin.Context) string {
 	token := getHeader(gctx, commons.Token)
 	if len(token) == 0 {
@@ -10,29 +10,31 @@ var AllowOrigins = []string{
 	"*",
 }
 
+// AllowHeaders 跨域允许的请求头，客户端请求头ClientHeaders会自动追加，不需要在这里重复
 var AllowHeaders = []string{
 	commons.TraceId,
 	commons.Platform,
 	commons.Token,
 	commons.ShareToken,
+	RequestIdHeader,
+	TraceParentHeader,
+	B3TraceIdHeader,
 
-	"device-id",
-	"hardware",
-	"os",
-	"os-version",
-	"resolution",
-	"app-key",
-	"app-version",
-	"app_vsn",
 	"X-Forwarded-For",
 	"X-Forwarded-Proto",
 	"Authorization",
 }
 
-func corsHandler() gin.HandlerFunc {
+func corsHandler(opts *EngineOptions) gin.HandlerFunc {
 	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowOrigins = AllowOrigins
-	corsConfig.AllowHeaders = AllowHeaders
+	corsConfig.AllowOrigins = opts.allowOrigins()
+	corsConfig.AllowHeaders = corsAllowHeaders(opts)
+	if header := opts.traceIdResponseHeader(); header != "" {
+		corsConfig.AddExposeHeaders(header)
+	}
+	if opts.serverTiming() {
+		corsConfig.AddExposeHeaders(ServerTimingHeader)
+	}
 
 	return cors.New(corsConfig)
 }
@@ -6,12 +6,24 @@ import (
 
 const MaxMultipartMemory = 2 << 20
 
+// NewEngine 创建gin.Engine，钩子和配置全部使用包级变量
 func NewEngine(ginMode string) *gin.Engine {
+	return NewEngineWithOptions(ginMode, nil)
+}
+
+// NewEngineWithOptions 创建gin.Engine，options用于给这个engine单独设置钩子和配置，为nil时与NewEngine相同
+func NewEngineWithOptions(ginMode string, options *EngineOptions) *gin.Engine {
 	gin.SetMode(ginMode)
 	e := gin.New()
 	e.UseH2C = true
 	e.MaxMultipartMemory = MaxMultipartMemory
-	e.Use(recoverHandler(), corsHandler(), monitorHandler(), healthHandler())
+	// 最外层的recoverHandler兜底用户中间件、访问日志和响应头中的panic，
+	// 路由内的panic由monitorHandler之后的recoverHandler处理，这样监控和用户中间件能拿到panic的结果
+	e.Use(optionsHandler(options), recoverHandler(), responseHeaderHandler(options))
+	if options != nil {
+		e.Use(options.Middlewares...)
+	}
+	e.Use(monitorHandler(), recoverHandler(), corsHandler(options), healthHandler())
 	_ = e.SetTrustedProxies(nil)
 	e.HandleMethodNotAllowed = true
 
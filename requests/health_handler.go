@@ -6,9 +6,11 @@ import (
 	"net/http"
 )
 
+const healthPath = "/health"
+
 func healthHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.RequestURI == "/health" {
+		if c.Request.RequestURI == healthPath {
 			baseContext := genBaseContext(c)
 			logger.WithBaseContextInfof(baseContext)("health check")
 			c.AbortWithStatusJSON(http.StatusOK, "ok")
@@ -0,0 +1,164 @@
+package requests
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rolandhe/go-base/monitor"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	requestTimingName = "request_timing_qweb"
+)
+
+var (
+	metricsOnce sync.Once
+
+	serverDuration     *prometheus.HistogramVec
+	serverAuthDuration *prometheus.HistogramVec
+	serverBizDuration  *prometheus.HistogramVec
+	serverInFlight     *prometheus.GaugeVec
+	serverRequestSize  *prometheus.HistogramVec
+	serverResponseSize *prometheus.HistogramVec
+)
+
+// sizeBuckets 100B到100MB
+var sizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+
+// initMetrics 在monitor.StartMonitor之后注册本包的监控指标，没有启动监控时返回false
+func initMetrics() bool {
+	if monitor.ServerReqDuration == nil {
+		return false
+	}
+	metricsOnce.Do(func() {
+		serverDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "server_request_duration_seconds",
+			Help: "Duration to server requests by route, method, status, outcome and result code.",
+		}, []string{"path", "method", "status", "outcome", "code"})
+		serverAuthDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "server_request_auth_duration_seconds",
+			Help: "Duration from request start to business handling, mainly login and authorization.",
+		}, []string{"path", "method"})
+		serverBizDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "server_request_biz_duration_seconds",
+			Help: "Duration of binding, validation and business function.",
+		}, []string{"path", "method"})
+		serverInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "server_requests_in_flight",
+			Help: "Server requests being handled.",
+		}, []string{"path", "method"})
+		serverRequestSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "server_request_size_bytes",
+			Help:    "Size of server request bodies.",
+			Buckets: sizeBuckets,
+		}, []string{"path", "method"})
+		serverResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "server_response_size_bytes",
+			Help:    "Size of server response bodies.",
+			Buckets: sizeBuckets,
+		}, []string{"path", "method"})
+	})
+	return true
+}
+
+// requestTiming 请求各阶段的时间点，由monitorHandler创建，doBizFunc填写业务处理的起止时间
+type requestTiming struct {
+	start    time.Time
+	bizStart time.Time
+	bizEnd   time.Time
+}
+
+func newRequestTiming(gctx *gin.Context) *requestTiming {
+	timing := &requestTiming{start: time.Now()}
+	gctx.Set(requestTimingName, timing)
+	return timing
+}
+
+func getRequestTiming(gctx *gin.Context) *requestTiming {
+	v, exists := gctx.Get(requestTimingName)
+	if !exists {
+		return nil
+	}
+	return v.(*requestTiming)
+}
+
+// authCost 从请求开始到业务处理开始的耗时，没有进入业务处理时返回false
+func (t *requestTiming) authCost() (time.Duration, bool) {
+	if t.bizStart.IsZero() {
+		return 0, false
+	}
+	return t.bizStart.Sub(t.start), true
+}
+
+func (t *requestTiming) bizCost() (time.Duration, bool) {
+	if t.bizStart.IsZero() || t.bizEnd.IsZero() {
+		return 0, false
+	}
+	return t.bizEnd.Sub(t.bizStart), true
+}
+
+func markBizStart(gctx *gin.Context) {
+	if timing := getRequestTiming(gctx); timing != nil {
+		timing.bizStart = time.Now()
+	}
+}
+
+func markBizEnd(gctx *gin.Context) {
+	if timing := getRequestTiming(gctx); timing != nil {
+		timing.bizEnd = time.Now()
+	}
+}
+
+func incInFlight(path string, method string) {
+	if !initMetrics() {
+		return
+	}
+	serverInFlight.WithLabelValues(path, method).Inc()
+}
+
+func decInFlight(path string, method string) {
+	if !initMetrics() {
+		return
+	}
+	serverInFlight.WithLabelValues(path, method).Dec()
+}
+
+// doServerMetrics 请求结束后记录耗时和大小
+func doServerMetrics(gctx *gin.Context, path string, timing *requestTiming, kind ResultKind, code int) {
+	if !initMetrics() {
+		return
+	}
+	method := gctx.Request.Method
+	serverDuration.WithLabelValues(path, method, strconv.Itoa(gctx.Writer.Status()), kind.Outcome(), strconv.Itoa(code)).
+		Observe(time.Since(timing.start).Seconds())
+	if cost, ok := timing.authCost(); ok {
+		serverAuthDuration.WithLabelValues(path, method).Observe(cost.Seconds())
+	}
+	if cost, ok := timing.bizCost(); ok {
+		serverBizDuration.WithLabelValues(path, method).Observe(cost.Seconds())
+	}
+	serverRequestSize.WithLabelValues(path, method).Observe(float64(requestSize(gctx)))
+	serverResponseSize.WithLabelValues(path, method).Observe(float64(responseSize(gctx)))
+}
+
+// requestSize 请求体的字节数，chunked请求没有Content-Length，使用已缓存的请求体长度
+func requestSize(gctx *gin.Context) int64 {
+	if gctx.Request.ContentLength >= 0 {
+		return gctx.Request.ContentLength
+	}
+	if body, exists := gctx.Get(gin.BodyBytesKey); exists {
+		return int64(len(body.([]byte)))
+	}
+	return 0
+}
+
+func responseSize(gctx *gin.Context) int64 {
+	size := gctx.Writer.Size()
+	if size < 0 {
+		return 0
+	}
+	return int64(size)
+}
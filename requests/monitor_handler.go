@@ -6,18 +6,37 @@ import (
 	"time"
 )
 
+// UnmatchedRoute 没有匹配到路由的请求在监控中使用的path标签，避免扫描等随机路径造成标签数量无限增长
+const UnmatchedRoute = "unmatched"
+
 func monitorHandler() gin.HandlerFunc {
 	return func(gctx *gin.Context) {
-		path := gctx.Request.URL.Path
+		path := routeLabel(gctx)
 		monitor.DoServerCounter(path)
-		start := time.Now().UnixMilli()
+		timing := newRequestTiming(gctx)
+		incInFlight(path, gctx.Request.Method)
+		defer decInFlight(path, gctx.Request.Method)
 		gctx.Next()
 
-		cost := time.Now().UnixMilli() - start
+		cost := time.Since(timing.start).Milliseconds()
+		kind, code := RequestOutcome(gctx)
 		e := "false"
-		if len(gctx.Errors) > 0 {
+		if kind != ResultOK {
 			e = "true"
 		}
 		monitor.DoServerDuration(path, e, cost)
+		doServerMetrics(gctx, path, timing, kind, code)
+		doAccessLog(gctx, path, timing, kind, code)
+	}
+}
+
+// routeLabel 监控使用的path标签，使用注册路由时的模板(如/api/user/:id)而不是实际的url
+func routeLabel(gctx *gin.Context) string {
+	if fullPath := gctx.FullPath(); fullPath != "" {
+		return fullPath
+	}
+	if gctx.Request.RequestURI == healthPath {
+		return healthPath
 	}
+	return UnmatchedRoute
 }
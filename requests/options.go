@@ -0,0 +1,258 @@
+package requests
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"github.com/rolandhe/go-base/logger"
+)
+
+const (
+	engineOptionsName = "engine_options_qweb"
+)
+
+// EngineOptions 单个gin.Engine使用的钩子和配置，通过NewEngineWithOptions传入，同一进程中的多个engine可以各自不同。
+// 未设置的字段在请求处理时使用同名的包级变量，因此只设置包级变量的旧用法保持不变
+type EngineOptions struct {
+	ApiUserInfoCheckFunc     func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error
+	ShareCheckFunc           func(ctx *commons.BaseContext, req any, urlPath string, info *commons.QuickInfo) error
+	PrivateUserInfoCheckFunc func(ctx *commons.BaseContext, uid int64, info *commons.QuickInfo) error
+	PublicUserInfoCheckFunc  func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error
+	RoleCheckFunc            func(ctx *commons.BaseContext, allowRoles []string, allowProducts []int, info *commons.QuickInfo) error
+	ProductFunc              func(ctx *commons.BaseContext, info *commons.QuickInfo) int
+	RequestLevelFunc         func(ctx *commons.BaseContext, urlPath string, originalLevel logger.LogLevel) logger.LogLevel
+	ConcurrentLimiterFunc    func(ctx *commons.BaseContext, urlPath string) (error, func())
+	RateLimiterFunc          func(ctx *commons.BaseContext, uPath string) error
+
+	// RoleValues 为nil时使用包级变量RoleValues
+	RoleValues map[string]int64
+
+	AllowOrigins []string
+	AllowHeaders []string
+	// ClientHeaders 为nil时使用包级变量ClientHeaders
+	ClientHeaders []ClientHeader
+
+	ValidationErrorMode ValidationErrorMode
+	LocaleFunc          func(ctx *commons.BaseContext) string
+
+	StatusMapper StatusMapper
+
+	// TraceIdResponseHeader 为空时使用包级变量TraceIdResponseHeader，ServerTiming和包级变量ServerTimingEnabled任一为true即开启
+	TraceIdResponseHeader string
+	ServerTiming          bool
+
+	// TraceIdHeaders 为nil时使用包级变量TraceIdHeaders
+	TraceIdHeaders   []string
+	TraceIdGenerator TraceIdGenerator
+
+	// LogMaskKeys 为nil时使用包级变量LogMaskKeys
+	LogMaskKeys []string
+	// MaxLogRequestBytes 和 MaxLogResponseBytes 为0时使用同名的包级变量，小于0表示不限制
+	MaxLogRequestBytes  int
+	MaxLogResponseBytes int
+
+	// AccessLogFunc 为nil时使用包级变量AccessLogFunc，DisableRequestLog和包级变量DisableRequestLog任一为true即关闭请求日志
+	AccessLogFunc     AccessLogSink
+	DisableRequestLog bool
+
+	StageFunc StageFunc
+	// Middlewares 在框架内置的中间件之前执行，例如链路追踪，可以通过SetTraceId设置请求的trace id
+	Middlewares []gin.HandlerFunc
+}
+
+// optionsHandler 把engine的配置放入请求上下文，供路由上的handler读取
+func optionsHandler(opts *EngineOptions) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		gctx.Set(engineOptionsName, opts)
+	}
+}
+
+// getEngineOptions 获取当前请求所属engine的配置，不是通过本包创建或者没有传入options的engine返回nil，此时全部使用包级变量
+func getEngineOptions(gctx *gin.Context) *EngineOptions {
+	v, exists := gctx.Get(engineOptionsName)
+	if !exists {
+		return nil
+	}
+	return v.(*EngineOptions)
+}
+
+// engineOptionsOf 通过BaseContext获取请求所属engine的配置，用于没有gin.Context的钩子中
+func engineOptionsOf(ctx *commons.BaseContext) *EngineOptions {
+	opts, _ := ctx.GetExtendValue(engineOptionsName).(*EngineOptions)
+	return opts
+}
+
+func (o *EngineOptions) apiUserInfoCheckFunc() func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+	if o != nil && o.ApiUserInfoCheckFunc != nil {
+		return o.ApiUserInfoCheckFunc
+	}
+	return ApiUserInfoCheckFunc
+}
+
+func (o *EngineOptions) shareCheckFunc() func(ctx *commons.BaseContext, req any, urlPath string, info *commons.QuickInfo) error {
+	if o != nil && o.ShareCheckFunc != nil {
+		return o.ShareCheckFunc
+	}
+	return ShareCheckFunc
+}
+
+func (o *EngineOptions) privateUserInfoCheckFunc() func(ctx *commons.BaseContext, uid int64, info *commons.QuickInfo) error {
+	if o != nil && o.PrivateUserInfoCheckFunc != nil {
+		return o.PrivateUserInfoCheckFunc
+	}
+	return PrivateUserInfoCheckFunc
+}
+
+func (o *EngineOptions) publicUserInfoCheckFunc() func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+	if o != nil && o.PublicUserInfoCheckFunc != nil {
+		return o.PublicUserInfoCheckFunc
+	}
+	return PublicUserInfoCheckFunc
+}
+
+func (o *EngineOptions) roleCheckFunc() func(ctx *commons.BaseContext, allowRoles []string, allowProducts []int, info *commons.QuickInfo) error {
+	if o != nil && o.RoleCheckFunc != nil {
+		return o.RoleCheckFunc
+	}
+	return RoleCheckFunc
+}
+
+func (o *EngineOptions) productFunc() func(ctx *commons.BaseContext, info *commons.QuickInfo) int {
+	if o != nil && o.ProductFunc != nil {
+		return o.ProductFunc
+	}
+	return ProductFunc
+}
+
+func (o *EngineOptions) roleValues() map[string]int64 {
+	if o != nil && o.RoleValues != nil {
+		return o.RoleValues
+	}
+	return RoleValues
+}
+
+func (o *EngineOptions) requestLevelFunc() func(ctx *commons.BaseContext, urlPath string, originalLevel logger.LogLevel) logger.LogLevel {
+	if o != nil && o.RequestLevelFunc != nil {
+		return o.RequestLevelFunc
+	}
+	return RequestLevelFunc
+}
+
+func (o *EngineOptions) concurrentLimiterFunc() func(ctx *commons.BaseContext, urlPath string) (error, func()) {
+	if o != nil && o.ConcurrentLimiterFunc != nil {
+		return o.ConcurrentLimiterFunc
+	}
+	return ConcurrentLimiterFunc
+}
+
+func (o *EngineOptions) rateLimiterFunc() func(ctx *commons.BaseContext, uPath string) error {
+	if o != nil && o.RateLimiterFunc != nil {
+		return o.RateLimiterFunc
+	}
+	return RateLimiterFunc
+}
+
+func (o *EngineOptions) allowOrigins() []string {
+	if o != nil && o.AllowOrigins != nil {
+		return o.AllowOrigins
+	}
+	return AllowOrigins
+}
+
+func (o *EngineOptions) allowHeaders() []string {
+	if o != nil && o.AllowHeaders != nil {
+		return o.AllowHeaders
+	}
+	return AllowHeaders
+}
+
+func (o *EngineOptions) validationErrorMode() ValidationErrorMode {
+	if o != nil && o.ValidationErrorMode != 0 {
+		return o.ValidationErrorMode
+	}
+	return ValidationErrorOutput
+}
+
+func (o *EngineOptions) localeFunc() func(ctx *commons.BaseContext) string {
+	if o != nil && o.LocaleFunc != nil {
+		return o.LocaleFunc
+	}
+	return LocaleFunc
+}
+
+func (o *EngineOptions) statusMapper() StatusMapper {
+	if o != nil && o.StatusMapper != nil {
+		return o.StatusMapper
+	}
+	return ResultStatusMapper
+}
+
+func (o *EngineOptions) stageFunc() StageFunc {
+	if o != nil && o.StageFunc != nil {
+		return o.StageFunc
+	}
+	return RequestStageFunc
+}
+
+func (o *EngineOptions) traceIdResponseHeader() string {
+	if o != nil && o.TraceIdResponseHeader != "" {
+		return o.TraceIdResponseHeader
+	}
+	return TraceIdResponseHeader
+}
+
+func (o *EngineOptions) serverTiming() bool {
+	return (o != nil && o.ServerTiming) || ServerTimingEnabled
+}
+
+func (o *EngineOptions) traceIdHeaders() []string {
+	if o != nil && o.TraceIdHeaders != nil {
+		return o.TraceIdHeaders
+	}
+	return TraceIdHeaders
+}
+
+func (o *EngineOptions) traceIdGenerator() TraceIdGenerator {
+	if o != nil && o.TraceIdGenerator != nil {
+		return o.TraceIdGenerator
+	}
+	return NewTraceIdFunc
+}
+
+func (o *EngineOptions) logMaskKeys() []string {
+	if o != nil && o.LogMaskKeys != nil {
+		return o.LogMaskKeys
+	}
+	return LogMaskKeys
+}
+
+func (o *EngineOptions) maxLogRequestBytes() int {
+	if o != nil && o.MaxLogRequestBytes != 0 {
+		return o.MaxLogRequestBytes
+	}
+	return MaxLogRequestBytes
+}
+
+func (o *EngineOptions) maxLogResponseBytes() int {
+	if o != nil && o.MaxLogResponseBytes != 0 {
+		return o.MaxLogResponseBytes
+	}
+	return MaxLogResponseBytes
+}
+
+func (o *EngineOptions) accessLogFunc() AccessLogSink {
+	if o != nil && o.AccessLogFunc != nil {
+		return o.AccessLogFunc
+	}
+	return AccessLogFunc
+}
+
+func (o *EngineOptions) requestLogDisabled() bool {
+	return (o != nil && o.DisableRequestLog) || DisableRequestLog
+}
+
+func (o *EngineOptions) clientHeaders() []ClientHeader {
+	if o != nil && o.ClientHeaders != nil {
+		return o.ClientHeaders
+	}
+	return ClientHeaders
+}
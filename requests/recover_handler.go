@@ -6,7 +6,6 @@ import (
 	"github.com/rolandhe/go-base/envsupport"
 	"github.com/rolandhe/go-base/logger"
 	"go/types"
-	"net/http"
 	"strings"
 )
 
@@ -26,23 +25,29 @@ func myRecover(gctx *gin.Context, err any) {
 	baseCtx := genBaseContext(gctx)
 	logger.WithBaseContextErrorf(baseCtx)("panic error: %v", err)
 
-	gctx.JSON(http.StatusOK, errorToResult(err))
 	gctx.Abort()
+	// 已经输出了响应(例如访问日志中panic)时只记录日志
+	if gctx.Writer.Written() {
+		return
+	}
+	rt := errorToResult(err)
+	markResult(gctx, ResultPanic, rt)
+	renderResult(gctx, gctx.GetString(producesName), getEngineOptions(gctx).statusMapper()(ResultPanic, rt), rt)
 }
 
 func errorToResult(r any) any {
 	switch v := r.(type) {
 	case string:
-		return commons.QuickErrResult(v)
+		return commons.ErrResult(errorCode(InternalError), v)
 	case *commons.StdError:
 		return commons.NewResult[*types.Nil](v.Code, v.Message, nil)
 	case error:
 		if envsupport.Profile() == "prod" {
-			return commons.QuickErrResult("internal server error")
+			return commons.QuickFromError(InternalError)
 		} else {
-			return commons.QuickErrResult(v.Error())
+			return commons.ErrResult(errorCode(InternalError), v.Error())
 		}
 	default:
-		return commons.QuickErrResult("unknown error")
+		return commons.ErrResult(errorCode(InternalError), "unknown error")
 	}
 }
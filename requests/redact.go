@@ -0,0 +1,280 @@
+package requests
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rolandhe/go-base/commons"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 字段的log tag，mask时日志中替换为MaskedValue，omit时日志中不输出该字段
+const (
+	logTagName = "log"
+	logTagMask = "mask"
+	logTagOmit = "omit"
+)
+
+const MaskedValue = "******"
+
+// LogMaskKeys 请求和响应日志中需要脱敏的json key和query参数，不区分大小写，对任意层级生效，
+// 不需要在结构体上声明log tag
+var LogMaskKeys = []string{
+	"password",
+	"passwd",
+	"secret",
+	commons.Token,
+	commons.ShareToken,
+	"access_token",
+	"accessToken",
+	"refresh_token",
+	"refreshToken",
+	"authorization",
+}
+
+type redactAction int
+
+const (
+	redactKeep redactAction = iota
+	redactMask
+	redactOmit
+)
+
+// redactField 字段的脱敏方式，elem是字段值(或其元素)的结构体对应的plan，isMap时elem作用于map的每个value
+type redactField struct {
+	action redactAction
+	elem   *redactPlan
+	isMap  bool
+}
+
+// redactPlan 由结构体的log tag生成，fields的key是json名和form名
+type redactPlan struct {
+	fields map[string]*redactField
+}
+
+var redactPlans sync.Map
+
+// redactPlanOf 获取类型对应的plan，按类型缓存，没有任何log tag的类型返回nil
+func redactPlanOf(t reflect.Type) *redactPlan {
+	if t == nil {
+		return nil
+	}
+	t = indirectType(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if v, ok := redactPlans.Load(t); ok {
+		return v.(*redactPlan)
+	}
+	plan := buildRedactPlan(t, map[reflect.Type]*redactPlan{})
+	v, _ := redactPlans.LoadOrStore(t, plan)
+	return v.(*redactPlan)
+}
+
+func buildRedactPlan(t reflect.Type, building map[reflect.Type]*redactPlan) *redactPlan {
+	if plan, ok := building[t]; ok {
+		return plan
+	}
+	plan := &redactPlan{fields: map[string]*redactField{}}
+	building[t] = plan
+	collectRedactFields(t, plan, building)
+	if len(plan.fields) == 0 {
+		building[t] = nil
+		return nil
+	}
+	return plan
+}
+
+func collectRedactFields(t reflect.Type, plan *redactPlan, building map[reflect.Type]*redactPlan) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if jsonName == "-" {
+			continue
+		}
+		ft := indirectType(f.Type)
+		if f.Anonymous && jsonName == "" && ft.Kind() == reflect.Struct {
+			collectRedactFields(ft, plan, building)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		rf := &redactField{}
+		switch f.Tag.Get(logTagName) {
+		case logTagMask:
+			rf.action = redactMask
+		case logTagOmit:
+			rf.action = redactOmit
+		default:
+			if et := elemStructType(ft); et != nil {
+				rf.elem = buildRedactPlan(et, building)
+			}
+		}
+		if rf.action == redactKeep && rf.elem == nil {
+			continue
+		}
+		rf.isMap = ft.Kind() == reflect.Map
+		if jsonName == "" {
+			jsonName = f.Name
+		}
+		plan.fields[jsonName] = rf
+		if formName, _, _ := strings.Cut(f.Tag.Get("form"), ","); formName != "" && formName != "-" {
+			plan.fields[formName] = rf
+		}
+	}
+}
+
+// elemStructType 返回字段值本身或slice、array、map元素的结构体类型
+func elemStructType(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		t = indirectType(t.Elem())
+	}
+	if t.Kind() == reflect.Struct {
+		return t
+	}
+	return nil
+}
+
+func (p *redactPlan) lookup(key string) *redactField {
+	if p == nil {
+		return nil
+	}
+	if f, ok := p.fields[key]; ok {
+		return f
+	}
+	// encoding/json按字段名匹配时不区分大小写
+	for k, f := range p.fields {
+		if strings.EqualFold(k, key) {
+			return f
+		}
+	}
+	return nil
+}
+
+func isMaskKey(key string, maskKeys []string) bool {
+	for _, k := range maskKeys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactJSON 对json内容脱敏，不是合法json时原样返回
+func redactJSON(data []byte, plan *redactPlan, maskKeys []string) string {
+	if plan == nil && len(maskKeys) == 0 {
+		return string(data)
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return string(data)
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return string(data)
+	}
+	if !redactValue(v, plan, maskKeys) {
+		return string(data)
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return string(data)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// redactValue 就地脱敏，返回是否有修改
+func redactValue(v any, plan *redactPlan, maskKeys []string) bool {
+	changed := false
+	switch val := v.(type) {
+	case map[string]any:
+		for k, fv := range val {
+			f := plan.lookup(k)
+			switch {
+			case f != nil && f.action == redactOmit:
+				delete(val, k)
+				changed = true
+			case (f != nil && f.action == redactMask) || isMaskKey(k, maskKeys):
+				val[k] = MaskedValue
+				changed = true
+			default:
+				if redactChild(fv, f, maskKeys) {
+					changed = true
+				}
+			}
+		}
+	case []any:
+		for _, ev := range val {
+			if redactValue(ev, plan, maskKeys) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func redactChild(v any, f *redactField, maskKeys []string) bool {
+	if f == nil {
+		return redactValue(v, nil, maskKeys)
+	}
+	m, ok := v.(map[string]any)
+	if !f.isMap || !ok {
+		return redactValue(v, f.elem, maskKeys)
+	}
+	changed := false
+	for k, mv := range m {
+		if isMaskKey(k, maskKeys) {
+			m[k] = MaskedValue
+			changed = true
+		} else if redactValue(mv, f.elem, maskKeys) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// redactQuery 对query参数脱敏，没有需要脱敏的参数时返回false
+func redactQuery(rawQuery string, plan *redactPlan, maskKeys []string) (string, bool) {
+	if rawQuery == "" || (plan == nil && len(maskKeys) == 0) {
+		return rawQuery, false
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery, false
+	}
+	changed := false
+	for k := range values {
+		f := plan.lookup(k)
+		switch {
+		case f != nil && f.action == redactOmit:
+			values.Del(k)
+			changed = true
+		case (f != nil && f.action == redactMask) || isMaskKey(k, maskKeys):
+			values[k] = []string{MaskedValue}
+			changed = true
+		}
+	}
+	if !changed {
+		return rawQuery, false
+	}
+	return values.Encode(), true
+}
+
+// redactURL 返回脱敏后用于日志的url
+func redactURL(u *url.URL, plan *redactPlan, maskKeys []string) string {
+	q, changed := redactQuery(u.RawQuery, plan, maskKeys)
+	if !changed {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = q
+	return c.String()
+}
@@ -1,10 +1,36 @@
 package requests
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 	"github.com/rolandhe/go-base/commons"
+	"github.com/rolandhe/go-base/logger"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
 func Test1(t *testing.T) {
 	ret := &commons.Result[string]{
 		Code: 200,
@@ -17,3 +43,1259 @@ func Test1(t *testing.T) {
 		t.Log(cr.GetCode())
 	}
 }
+
+type nameReq struct {
+	Name string `json:"name" form:"name" binding:"required"`
+}
+
+func echoName(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+	return commons.OkResult(req.Name)
+}
+
+func doRequest(e *gin.Engine, method string, target string, body string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w
+}
+
+func TestMethodBinding(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	g := e.Group("/public")
+	rd := &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/name",
+		BizCoreFunc:  echoName,
+	}
+	Put(g, rd)
+	Delete(g, rd)
+	Patch(g, rd)
+	Handle(g, http.MethodOptions, rd)
+
+	cases := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodPut, "/public/name", `{"name":"x"}`},
+		{http.MethodPatch, "/public/name", `{"name":"x"}`},
+		{http.MethodDelete, "/public/name?name=x", ""},
+		{http.MethodOptions, "/public/name", `{"name":"x"}`},
+	}
+	for _, c := range cases {
+		w := doRequest(e, c.method, c.target, c.body)
+		if !strings.Contains(w.Body.String(), `"data":"x"`) {
+			t.Errorf("%s: unexpected body %s", c.method, w.Body.String())
+		}
+	}
+}
+
+func TestHead(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	Head(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/name",
+		BizCoreFunc:  echoName,
+	})
+	// 通过真实的http server请求，HEAD响应不带body
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := http.Head(srv.URL + "/public/name?name=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Errorf("status %d, body %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("content type %s", ct)
+	}
+	if resp, err = http.Get(srv.URL + "/public/name?name=x"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("GET on HEAD route: status %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestAuthMode(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		ApiUserInfoCheckFunc: func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+			return nil
+		},
+	})
+	g := e.Group("/public")
+	Get(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/open",
+		BizCoreFunc:  echoName,
+	})
+	Get(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/locked",
+		AuthMode:     AuthModeApi,
+		BizCoreFunc:  echoName,
+	})
+	Get(e.Group("/inner"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/open",
+		AuthMode:     AuthModeNone,
+		BizCoreFunc:  echoName,
+	})
+
+	if w := doRequest(e, http.MethodGet, "/public/open?name=x", ""); !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("public route rejected: %s", w.Body.String())
+	}
+	if w := doRequest(e, http.MethodGet, "/inner/open?name=x", ""); !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("none route rejected: %s", w.Body.String())
+	}
+	if w := doRequest(e, http.MethodGet, "/public/locked?name=x", ""); !strings.Contains(w.Body.String(), `{"code":5103,"errMsg":"not login"}`) {
+		t.Errorf("api route under /public/ passed without login: %s", w.Body.String())
+	}
+}
+
+func TestAllowRoles(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		ApiUserInfoCheckFunc: func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+			info.Uid = 1
+			if token == "admin" {
+				info.Roles = commons.AdminRole
+			}
+			return nil
+		},
+	})
+	Get(e.Group("/api"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/admin",
+		AllowRoles:   []string{"admin"},
+		BizCoreFunc:  echoName,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin?name=x", nil)
+	req.Header.Set(commons.Token, "user")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"code":5403`) {
+		t.Errorf("user passed admin route: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin?name=x", nil)
+	req.Header.Set(commons.Token, "admin")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("admin rejected: %s", w.Body.String())
+	}
+}
+
+func TestAllowProducts(t *testing.T) {
+	login := func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+		info.Uid = 1
+		info.CompanyId = 9
+		return nil
+	}
+	rd := &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath:  "/product",
+		AllowProducts: []int{9},
+		BizCoreFunc:   echoName,
+	}
+	unset := NewEngineWithOptions(gin.TestMode, &EngineOptions{ApiUserInfoCheckFunc: login})
+	fromUser := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		ApiUserInfoCheckFunc: login,
+		ProductFunc: func(ctx *commons.BaseContext, info *commons.QuickInfo) int {
+			return int(info.CompanyId)
+		},
+	})
+	Get(unset.Group("/api"), rd)
+	Get(fromUser.Group("/api"), rd)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/product?name=x", nil)
+	req.Header.Set(commons.Platform, "9")
+	w := httptest.NewRecorder()
+	unset.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":5403`) {
+		t.Errorf("unset ProductFunc should forbid: %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(fromUser, http.MethodGet, "/api/product?name=x", ""); !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("product from user info rejected: %s", w.Body.String())
+	}
+}
+
+func TestRoleValuesPerEngine(t *testing.T) {
+	login := func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+		info.Uid = 1
+		info.Roles = commons.AdminRole
+		return nil
+	}
+	rd := &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/ops",
+		AllowRoles:   []string{"ops"},
+		BizCoreFunc:  echoName,
+	}
+	withOps := NewEngineWithOptions(gin.TestMode, &EngineOptions{ApiUserInfoCheckFunc: login, RoleValues: map[string]int64{"ops": commons.AdminRole}})
+	plain := NewEngineWithOptions(gin.TestMode, &EngineOptions{ApiUserInfoCheckFunc: login})
+	Get(withOps.Group("/api"), rd)
+	Get(plain.Group("/api"), rd)
+
+	if w := doRequest(withOps, http.MethodGet, "/api/ops?name=x", ""); !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("ops role rejected: %s", w.Body.String())
+	}
+	if w := doRequest(plain, http.MethodGet, "/api/ops?name=x", ""); !strings.Contains(w.Body.String(), `"code":5403`) {
+		t.Errorf("unknown role passed: %s", w.Body.String())
+	}
+}
+
+func TestEngineOptionsIsolation(t *testing.T) {
+	checkFunc := func(uid int64) func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+		return func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+			info.Uid = uid
+			return nil
+		}
+	}
+	admin := NewEngineWithOptions(gin.TestMode, &EngineOptions{ApiUserInfoCheckFunc: checkFunc(1)})
+	open := NewEngineWithOptions(gin.TestMode, &EngineOptions{ApiUserInfoCheckFunc: checkFunc(0)})
+	for _, e := range []*gin.Engine{admin, open} {
+		Get(e.Group("/api"), &RequestDesc[nameReq, *commons.Result[string]]{
+			RelativePath: "/name",
+			BizCoreFunc:  echoName,
+		})
+	}
+
+	if w := doRequest(admin, http.MethodGet, "/api/name?name=x", ""); !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("admin engine rejected: %s", w.Body.String())
+	}
+	if w := doRequest(open, http.MethodGet, "/api/name?name=x", ""); strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("open engine used another engine's hook: %s", w.Body.String())
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	g := e.Group("/public")
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/name",
+		BizCoreFunc:  echoName,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/public/name", strings.NewReader("name=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("form body not bound: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/public/name", strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Accept", "application/x-msgpack")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/msgpack") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+
+	// msgpack编码的{"name":"x"}
+	req = httptest.NewRequest(http.MethodPost, "/public/name", bytes.NewReader([]byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'x'}))
+	req.Header.Set("Content-Type", binding.MIMEMSGPACK)
+	req.Header.Set("Accept", binding.MIMEJSON)
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("msgpack body not bound: %s", w.Body.String())
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("name", "x")
+	_ = mw.Close()
+	req = httptest.NewRequest(http.MethodPost, "/public/name", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"x"`) {
+		t.Errorf("multipart body not bound: %s", w.Body.String())
+	}
+
+	Post(g, &RequestDesc[wrapperspb.StringValue, *wrapperspb.StringValue]{
+		RelativePath: "/proto",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *wrapperspb.StringValue) *wrapperspb.StringValue {
+			return wrapperspb.String(req.GetValue() + "!")
+		},
+	})
+	in, _ := proto.Marshal(wrapperspb.String("x"))
+	req = httptest.NewRequest(http.MethodPost, "/public/proto", bytes.NewReader(in))
+	req.Header.Set("Content-Type", binding.MIMEPROTOBUF)
+	req.Header.Set("Accept", binding.MIMEPROTOBUF)
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	out := &wrapperspb.StringValue{}
+	if err := proto.Unmarshal(w.Body.Bytes(), out); err != nil || out.GetValue() != "x!" {
+		t.Errorf("protobuf round trip: %v %q", err, out.GetValue())
+	}
+
+	// panic的结果同样按路由的Produces输出
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/panic",
+		Produces:     binding.MIMEMSGPACK,
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			panic("boom")
+		},
+	})
+	w = doRequest(e, http.MethodPost, "/public/panic", `{"name":"x"}`)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/msgpack") {
+		t.Errorf("panic result content type %s", ct)
+	}
+}
+
+func TestFormBodyLog(t *testing.T) {
+	conf := &bodyLogConf{maskKeys: LogMaskKeys, maxBytes: -1}
+	gctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=x&password=p"))
+	gctx.Request.Header.Set("Content-Type", binding.MIMEPOSTForm)
+	_ = gctx.Request.ParseForm()
+	if got := conf.requestBody(gctx); got != "name=x&password=%2A%2A%2A%2A%2A%2A" {
+		t.Errorf("got %s", got)
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("name", "x")
+	_ = mw.Close()
+	gctx.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body.Bytes()))
+	gctx.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	_ = gctx.Request.ParseMultipartForm(MaxMultipartMemory)
+	if got := conf.requestBody(gctx); got != fmt.Sprintf("<multipart/form-data %d bytes> name=x", body.Len()) {
+		t.Errorf("got %s", got)
+	}
+}
+
+type userReq struct {
+	Id     int64  `uri:"id" json:"-" binding:"required,gt=0"`
+	Device string `header:"device-id" json:"-" binding:"required"`
+	Name   string `json:"name" binding:"required"`
+}
+
+func TestBindPathAndHeader(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	Put(e.Group("/public"), &RequestDesc[userReq, *commons.Result[string]]{
+		RelativePath: "/user/:id",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *userReq) *commons.Result[string] {
+			return commons.OkResult(fmt.Sprintf("%d-%s-%s", req.Id, req.Device, req.Name))
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "/public/user/7", strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Device-Id", "d1")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"7-d1-x"`) {
+		t.Errorf("path and header not bound: %s", w.Body.String())
+	}
+
+	if w = doRequest(e, http.MethodPut, "/public/user/7", `{"name":"x"}`); strings.Contains(w.Body.String(), `"data"`) {
+		t.Errorf("missing header passed validation: %s", w.Body.String())
+	}
+}
+
+type pathIdReq struct {
+	Id     int64  `uri:"id" binding:"required,gt=0"`
+	Device string `header:"device-id" json:"device"`
+}
+
+func TestPathAndHeaderWin(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	desc := &RequestDesc[pathIdReq, *commons.Result[string]]{
+		RelativePath: "/u/:id",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *pathIdReq) *commons.Result[string] {
+			return commons.OkResult(fmt.Sprintf("%d-%s", req.Id, req.Device))
+		},
+	}
+	Get(e.Group("/public"), desc)
+	Post(e.Group("/public"), desc)
+
+	req := httptest.NewRequest(http.MethodGet, "/public/u/7?Id=8&Device=d2", nil)
+	req.Header.Set("Device-Id", "d1")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"7-d1"`) {
+		t.Errorf("query overrode path or header: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/public/u/7", strings.NewReader(`{"Id":-1,"device":"d2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Device-Id", "d1")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"data":"7-d1"`) {
+		t.Errorf("body overrode path or header: %s", w.Body.String())
+	}
+}
+
+type itemReq struct {
+	Title string `json:"title" binding:"required"`
+}
+
+type orderReq struct {
+	Items []*itemReq `json:"items" binding:"required,dive"`
+}
+
+func TestFrameworkErrorCodes(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	g := e.Group("/public")
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{RelativePath: "/name", BizCoreFunc: echoName})
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/panic",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			panic("boom")
+		},
+	})
+	Post(g, &RequestDesc[nameReq, string]{
+		RelativePath: "/fail",
+		BizCoreFuncE: func(ctx *commons.BaseContext, req *nameReq) (string, error) {
+			return "", errors.New("db down")
+		},
+	})
+
+	// 参数错误默认与原来的QuickErrResult一样返回CommonErr
+	if w := doRequest(e, http.MethodPost, "/public/name", `{}`); !strings.Contains(w.Body.String(), `"code":5000`) {
+		t.Errorf("args invalid code changed: %s", w.Body.String())
+	}
+
+	old := InternalError
+	InternalError = commons.NewError(5999, "oops")
+	defer func() { InternalError = old }()
+	if w := doRequest(e, http.MethodPost, "/public/panic", `{"name":"x"}`); !strings.Contains(w.Body.String(), `"code":5999`) {
+		t.Errorf("string panic ignored InternalError: %s", w.Body.String())
+	}
+	if w := doRequest(e, http.MethodPost, "/public/fail", `{"name":"x"}`); !strings.Contains(w.Body.String(), `{"code":5999,"errMsg":"oops"}`) {
+		t.Errorf("plain biz error ignored InternalError: %s", w.Body.String())
+	}
+}
+
+func TestHookErrorCodes(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		StatusMapper: HttpStatus,
+		ApiUserInfoCheckFunc: func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+			if token == "expired" {
+				return NotLoginError
+			}
+			return errors.New("redis timeout")
+		},
+	})
+	Get(e.Group("/api"), &RequestDesc[nameReq, *commons.Result[string]]{RelativePath: "/name", BizCoreFunc: echoName})
+
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/name?name=x", nil)
+		req.Header.Set(commons.Token, token)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w
+	}
+	// 用户服务故障不能让客户端退出登录
+	if w := send("valid"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"code":5000`) {
+		t.Errorf("backend error: %d %s", w.Code, w.Body.String())
+	}
+	if w := send("expired"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"code":5103`) {
+		t.Errorf("not login: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStructuredValidationError(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{ValidationErrorMode: ValidationErrorStructured})
+	Post(e.Group("/public"), &RequestDesc[orderReq, *commons.Result[string]]{
+		RelativePath: "/order",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *orderReq) *commons.Result[string] {
+			return commons.OkResult("ok")
+		},
+	})
+
+	w := doRequest(e, http.MethodPost, "/public/order", `{"items":[{"title":"a"},{}]}`)
+	body := w.Body.String()
+	// 结构化错误使用与InternalError不同的参数错误码
+	if !strings.Contains(body, `"code":4100`) || !strings.Contains(body, `"field":"items[1].title","tag":"required"`) {
+		t.Errorf("unexpected structured error: %s", body)
+	}
+}
+
+type searchReq struct {
+	Keyword string `json:"keyword" form:"q" binding:"required"`
+}
+
+func TestFieldErrorSource(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{ValidationErrorMode: ValidationErrorStructured})
+	g := e.Group("/public")
+	Post(g, &RequestDesc[userReq, *commons.Result[string]]{
+		RelativePath: "/u/:id",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *userReq) *commons.Result[string] {
+			return commons.OkResult(req.Name)
+		},
+	})
+	Get(g, &RequestDesc[searchReq, *commons.Result[string]]{
+		RelativePath: "/search",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *searchReq) *commons.Result[string] {
+			return commons.OkResult(req.Keyword)
+		},
+	})
+
+	body := doRequest(e, http.MethodPost, "/public/u/0", `{}`).Body.String()
+	for _, field := range []string{`"field":"id"`, `"field":"device-id"`, `"field":"name"`} {
+		if !strings.Contains(body, field) {
+			t.Errorf("expect %s in %s", field, body)
+		}
+	}
+	if body := doRequest(e, http.MethodGet, "/public/search", "").Body.String(); !strings.Contains(body, `"field":"q"`) {
+		t.Errorf("query field: %s", body)
+	}
+}
+
+type localeReq struct {
+	Name string `json:"name" binding:"required"`
+	Age  int    `json:"age" binding:"gt=0" errMsg:"年龄必须大于0" errMsg_en:"age must be positive"`
+}
+
+func TestLocalizedValidationError(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	Post(e.Group("/public"), &RequestDesc[localeReq, *commons.Result[string]]{
+		RelativePath: "/locale",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *localeReq) *commons.Result[string] {
+			return commons.OkResult("ok")
+		},
+	})
+
+	cases := map[string][]string{
+		"zh-CN,zh;q=0.9": {"Name为必填字段", "年龄必须大于0"},
+		"en-US":          {"Name is a required field", "age must be positive"},
+	}
+	for lang, expected := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/public/locale", strings.NewReader(`{}`))
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		for _, msg := range expected {
+			if !strings.Contains(w.Body.String(), msg) {
+				t.Errorf("%s: expect %s in %s", lang, msg, w.Body.String())
+			}
+		}
+	}
+}
+
+type metaBase struct {
+	Owner string `errMsg:"owner required"`
+}
+
+type metaInner struct {
+	Title string `errMsg:"title required" errMsg_en:"title is required"`
+}
+
+type metaReq struct {
+	metaBase
+	Inner metaInner
+	List  []metaInner
+	Dict  map[string]*metaInner
+	Self  *metaReq
+}
+
+func TestErrMsgMeta(t *testing.T) {
+	meta := newErrMsgMeta(reflect.TypeOf(new(metaReq)))
+	cases := []struct {
+		ns       string
+		locale   string
+		expected string
+	}{
+		{"metaReq.metaBase.Owner", "", "owner required"},
+		{"metaReq.Inner.Title", "zh", "title required"},
+		{"metaReq.Inner.Title", "en", "title is required"},
+		{"metaReq.List[3].Title", "", "title required"},
+		{"metaReq.Dict[a.b].Title", "", "title required"},
+		{"metaReq.Self.Inner.Title", "", "title required"},
+	}
+	for _, c := range cases {
+		msg, ok := meta.lookup(c.ns, c.locale)
+		if !ok || msg != c.expected {
+			t.Errorf("%s(%s): got %q, expect %q", c.ns, c.locale, msg, c.expected)
+		}
+	}
+	if _, ok := meta.lookup("metaReq.Self.Self.Inner.Title", ""); ok {
+		t.Error("self reference expanded more than one level")
+	}
+
+	tree := newErrMsgMeta(reflect.TypeOf(new(treeNode)))
+	if msg, ok := tree.lookup("treeNode.Children[0].Name", ""); !ok || msg != "name required" {
+		t.Errorf("recursive child: got %q", msg)
+	}
+
+	if got := jsonPath(reflect.TypeOf(new(treeNode)), "treeNode.Labels[a.b].Name"); got != "labels[a.b].name" {
+		t.Errorf("jsonPath with dotted map key: %s", got)
+	}
+}
+
+type treeNode struct {
+	Name     string               `json:"name" errMsg:"name required"`
+	Children []treeNode           `json:"children"`
+	Labels   map[string]*treeNode `json:"labels"`
+}
+
+type rangeReq struct {
+	Code  string `json:"code" binding:"required,upper_code"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+func (r *rangeReq) Validate(ctx *commons.BaseContext) error {
+	if r.End < r.Start {
+		return errors.New("end before start")
+	}
+	return nil
+}
+
+func TestCustomValidation(t *testing.T) {
+	err := RegisterValidation("upper_code", func(fl validator.FieldLevel) bool {
+		return strings.ToUpper(fl.Field().String()) == fl.Field().String()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RegisterTranslation("upper_code", "en", "{0} must be upper case"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine(gin.TestMode)
+	Post(e.Group("/public"), &RequestDesc[rangeReq, *commons.Result[string]]{
+		RelativePath: "/range",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *rangeReq) *commons.Result[string] {
+			return commons.OkResult("ok")
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/public/range", strings.NewReader(`{"code":"ab"}`))
+	req.Header.Set("Accept-Language", "en")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "Code must be upper case") {
+		t.Errorf("custom tag not applied: %s", w.Body.String())
+	}
+	if w = doRequest(e, http.MethodPost, "/public/range", `{"code":"AB","start":2,"end":1}`); !strings.Contains(w.Body.String(), "end before start") {
+		t.Errorf("Validate not called: %s", w.Body.String())
+	}
+	if w = doRequest(e, http.MethodPost, "/public/range", `{"code":"AB","start":1,"end":2}`); !strings.Contains(w.Body.String(), `"data":"ok"`) {
+		t.Errorf("valid request rejected: %s", w.Body.String())
+	}
+}
+
+func TestHttpStatusMapper(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		StatusMapper: HttpStatus,
+		ApiUserInfoCheckFunc: func(ctx *commons.BaseContext, token string, urlPath string, info *commons.QuickInfo) error {
+			return nil
+		},
+	})
+	Post(e.Group("/api"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/name",
+		BizCoreFunc:  echoName,
+	})
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/name",
+		BizCoreFunc:  echoName,
+	})
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/panic",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			panic("boom")
+		},
+	})
+	Post(e.Group("/public"), &RequestDesc[nameReq, string]{
+		RelativePath: "/fail",
+		BizCoreFuncE: func(ctx *commons.BaseContext, req *nameReq) (string, error) {
+			if req.Name == "args" {
+				return "", ArgsInvalidError
+			}
+			return "", InternalError
+		},
+	})
+
+	cases := []struct {
+		target string
+		body   string
+		status int
+	}{
+		{"/api/name", `{"name":"x"}`, http.StatusUnauthorized},
+		{"/public/name", `{}`, http.StatusBadRequest},
+		{"/public/name", `{"name":"x"}`, http.StatusOK},
+		{"/public/panic", `{"name":"x"}`, http.StatusInternalServerError},
+		{"/public/fail", `{"name":"args"}`, http.StatusBadRequest},
+		{"/public/fail", `{"name":"x"}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := doRequest(e, http.MethodPost, c.target, c.body); w.Code != c.status {
+			t.Errorf("%s %s: status %d, expect %d", c.target, c.body, w.Code, c.status)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	canceled := make(chan struct{})
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/slow",
+		Timeout:      20 * time.Millisecond,
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			<-RequestContext(ctx).Done()
+			close(canceled)
+			return commons.OkResult(req.Name)
+		},
+	})
+
+	w := doRequest(e, http.MethodPost, "/public/slow", `{"name":"x"}`)
+	if !strings.Contains(w.Body.String(), `"code":5504`) {
+		t.Errorf("expect timeout result: %s", w.Body.String())
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("biz function did not observe cancellation")
+	}
+}
+
+func TestTimeoutHoldsLimiterAndHeaders(t *testing.T) {
+	var slots atomic.Int32
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		ConcurrentLimiterFunc: func(ctx *commons.BaseContext, urlPath string) (error, func()) {
+			slots.Add(1)
+			return nil, func() { slots.Add(-1) }
+		},
+	})
+	proceed := make(chan struct{})
+	seen := make(chan string, 1)
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/slow",
+		Timeout:      20 * time.Millisecond,
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			if req.Name == "fast" {
+				return commons.OkResult(req.Name)
+			}
+			<-proceed
+			seen <- ctx.GetExtendStringValue("X-Tag")
+			return commons.OkResult(req.Name)
+		},
+	})
+
+	send := func(name string, tag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/public/slow", strings.NewReader(`{"name":"`+name+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Tag", tag)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w
+	}
+	if w := send("slow", "first"); !strings.Contains(w.Body.String(), `"code":5504`) {
+		t.Fatalf("expect timeout result: %s", w.Body.String())
+	}
+	if n := slots.Load(); n != 1 {
+		t.Errorf("limiter slot released before biz exited, slots=%d", n)
+	}
+	// 复用gin.Context的请求
+	send("fast", "second")
+	close(proceed)
+	if tag := <-seen; tag != "first" {
+		t.Errorf("timed-out biz read header %q", tag)
+	}
+	deadline := time.Now().Add(time.Second)
+	for slots.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := slots.Load(); n != 0 {
+		t.Errorf("limiter slot not released, slots=%d", n)
+	}
+}
+
+func TestTimeoutBizPut(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		AccessLogFunc: LoggerAccessLog,
+	})
+	exited := make(chan struct{})
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/slow",
+		Timeout:      10 * time.Millisecond,
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			defer close(exited)
+			<-RequestContext(ctx).Done()
+			// 框架返回超时结果、记录日志时业务函数仍在写BaseContext
+			for i := 0; i < 1000; i++ {
+				ctx.Put("step", strconv.Itoa(i))
+			}
+			return commons.OkResult(req.Name)
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/public/slow", strings.NewReader(`{"name":"slow"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"code":5504`) {
+		t.Fatalf("expect timeout result: %s", w.Body.String())
+	}
+	<-exited
+}
+
+func TestInterceptors(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	var steps []string
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/intercept",
+		Middlewares: []gin.HandlerFunc{func(gctx *gin.Context) {
+			steps = append(steps, "middleware")
+		}},
+		Before: func(ctx *commons.BaseContext, req *nameReq) error {
+			steps = append(steps, "before")
+			if req.Name == "deny" {
+				return commons.NewError(4001, "denied")
+			}
+			return nil
+		},
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			steps = append(steps, "biz")
+			return commons.OkResult(req.Name)
+		},
+		After: func(ctx *commons.BaseContext, req *nameReq, rt *commons.Result[string]) *commons.Result[string] {
+			steps = append(steps, "after")
+			rt.Data = rt.Data + "!"
+			return rt
+		},
+	})
+
+	if w := doRequest(e, http.MethodPost, "/public/intercept", `{"name":"x"}`); !strings.Contains(w.Body.String(), `"data":"x!"`) {
+		t.Errorf("after not applied: %s", w.Body.String())
+	}
+	if strings.Join(steps, ",") != "middleware,before,biz,after" {
+		t.Errorf("unexpected order: %v", steps)
+	}
+	if w := doRequest(e, http.MethodPost, "/public/intercept", `{"name":"deny"}`); !strings.Contains(w.Body.String(), `"code":4001`) {
+		t.Errorf("before error not returned: %s", w.Body.String())
+	}
+}
+
+func TestBizFuncE(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	var ginErrors int
+	Post(e.Group("/public"), &RequestDesc[nameReq, string]{
+		RelativePath: "/e",
+		Middlewares: []gin.HandlerFunc{func(gctx *gin.Context) {
+			gctx.Next()
+			ginErrors = len(gctx.Errors)
+		}},
+		BizCoreFuncE: func(ctx *commons.BaseContext, req *nameReq) (string, error) {
+			if req.Name == "bad" {
+				return "", commons.NewError(4002, "bad name")
+			}
+			return req.Name, nil
+		},
+	})
+
+	if w := doRequest(e, http.MethodPost, "/public/e", `{"name":"x"}`); !strings.Contains(w.Body.String(), `{"code":200,"errMsg":"","data":"x"}`) {
+		t.Errorf("success not wrapped: %s", w.Body.String())
+	}
+	w := doRequest(e, http.MethodPost, "/public/e", `{"name":"bad"}`)
+	if !strings.Contains(w.Body.String(), `"code":4002`) || ginErrors != 1 {
+		t.Errorf("error not converted or not marked: %s,%d", w.Body.String(), ginErrors)
+	}
+}
+
+func TestRequestOutcome(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	var outcome string
+	var code int
+	e.Use(func(gctx *gin.Context) {
+		gctx.Next()
+		kind, c := RequestOutcome(gctx)
+		outcome, code = kind.Outcome(), c
+	})
+	g := e.Group("/public")
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/fail",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			return commons.ErrTypeResult[string](4003, "fail")
+		},
+	})
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/ok",
+		BizCoreFunc:  echoName,
+	})
+
+	cases := []struct {
+		target  string
+		body    string
+		outcome string
+		code    int
+	}{
+		{"/public/fail", `{"name":"x"}`, "biz_error", 4003},
+		{"/public/ok", `{}`, "biz_error", commons.CommonErr},
+		{"/public/ok", `{"name":"x"}`, "ok", commons.OKCode},
+	}
+	for _, c := range cases {
+		doRequest(e, http.MethodPost, c.target, c.body)
+		if outcome != c.outcome || code != c.code {
+			t.Errorf("%s %s: got %s/%d, expect %s/%d", c.target, c.body, outcome, code, c.outcome, c.code)
+		}
+	}
+}
+
+func TestRouteLabel(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	var label string
+	e.Use(func(gctx *gin.Context) {
+		label = routeLabel(gctx)
+	})
+	Get(e.Group("/public"), &RequestDesc[userReq, *commons.Result[string]]{
+		RelativePath: "/user/:id",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *userReq) *commons.Result[string] {
+			return commons.OkResult("")
+		},
+	})
+
+	doRequest(e, http.MethodGet, "/public/user/42", "")
+	if label != "/public/user/:id" {
+		t.Errorf("unexpected label %s", label)
+	}
+	doRequest(e, http.MethodGet, "/wp-admin/setup.php", "")
+	if label != UnmatchedRoute {
+		t.Errorf("unexpected label for unmatched request %s", label)
+	}
+}
+
+func TestRequestTiming(t *testing.T) {
+	e := NewEngine(gin.TestMode)
+	var timing *requestTiming
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/timing",
+		Middlewares: []gin.HandlerFunc{func(gctx *gin.Context) {
+			timing = getRequestTiming(gctx)
+		}},
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			time.Sleep(5 * time.Millisecond)
+			return commons.OkResult(req.Name)
+		},
+	})
+
+	doRequest(e, http.MethodPost, "/public/timing", `{"name":"x"}`)
+	if timing == nil {
+		t.Fatal("timing not recorded")
+	}
+	authCost, ok1 := timing.authCost()
+	bizCost, ok2 := timing.bizCost()
+	if !ok1 || !ok2 || authCost < 0 || bizCost < 5*time.Millisecond {
+		t.Errorf("unexpected timing auth=%v biz=%v", authCost, bizCost)
+	}
+}
+
+func TestTraceIdResponseHeader(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		AllowOrigins:          []string{"http://allowed.com"},
+		TraceIdResponseHeader: "X-Request-Trace",
+		ServerTiming:          true,
+	})
+	g := e.Group("/public")
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{RelativePath: "/name", BizCoreFunc: echoName})
+	Post(g, &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/panic",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			panic("boom")
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/public/name", strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(commons.TraceId, "abc")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-Trace"); got != "abc" {
+		t.Errorf("trace header %q", got)
+	}
+	if st := w.Header().Get(ServerTimingHeader); !strings.Contains(st, "total;dur=") || !strings.Contains(st, "biz;dur=") {
+		t.Errorf("server timing %q", st)
+	}
+
+	cors := httptest.NewRequest(http.MethodGet, "/public/name", nil)
+	cors.Header.Set("Origin", "http://denied.com")
+	for name, r := range map[string]*http.Request{
+		"health":   httptest.NewRequest(http.MethodGet, "/health", nil),
+		"panic":    httptest.NewRequest(http.MethodPost, "/public/panic", strings.NewReader(`{"name":"x"}`)),
+		"cors":     cors,
+		"notFound": httptest.NewRequest(http.MethodGet, "/nowhere", nil),
+	} {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Header().Get("X-Request-Trace") == "" {
+			t.Errorf("%s: missing trace header, status %d", name, w.Code)
+		}
+	}
+
+	// 流式响应在写入内容之前Flush
+	e.GET("/public/stream", func(gctx *gin.Context) {
+		gctx.Writer.Flush()
+		_, _ = gctx.Writer.WriteString("data: x\n\n")
+	})
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public/stream", nil))
+	if h := w.Result().Header; h.Get("X-Request-Trace") == "" || h.Get(ServerTimingHeader) == "" {
+		t.Errorf("flushed headers %v", h)
+	}
+}
+
+func TestInboundTraceId(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	cases := []struct {
+		headers map[string]string
+		want    string
+	}{
+		{map[string]string{commons.TraceId: "t1", RequestIdHeader: "r1"}, "t1"},
+		{map[string]string{RequestIdHeader: "r1", TraceParentHeader: parent}, "r1"},
+		{map[string]string{commons.TraceId: "bad\nid", TraceParentHeader: parent}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{map[string]string{RequestIdHeader: strings.Repeat("a", MaxTraceIdLength+1), B3TraceIdHeader: "463ac35c9f6413ad"}, "463ac35c9f6413ad"},
+		{map[string]string{TraceParentHeader: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", B3TraceIdHeader: "xyz"}, ""},
+	}
+	for i, c := range cases {
+		gctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		gctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range c.headers {
+			gctx.Request.Header.Set(k, v)
+		}
+		if got, _ := inboundTraceId(gctx, TraceIdHeaders); got != c.want {
+			t.Errorf("case %d: got %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestTraceIdGenerator(t *testing.T) {
+	if id := UUIDv7TraceId(); len(id) != 32 || id[12] != '7' {
+		t.Errorf("uuid v7 %q", id)
+	}
+	a, b := ULIDTraceId(), ULIDTraceId()
+	if len(a) != 26 || a[:6] != b[:6] || !validTraceId(a) {
+		t.Errorf("ulid %q %q", a, b)
+	}
+	gen := NewSnowflakeTraceId(3)
+	seen := map[string]bool{}
+	last := int64(0)
+	for i := 0; i < 10000; i++ {
+		id := gen()
+		n, _ := strconv.ParseInt(id, 10, 64)
+		if seen[id] || n <= last || (n>>12)&0x3ff != 3 {
+			t.Fatalf("snowflake %s after %d", id, last)
+		}
+		seen[id] = true
+		last = n
+	}
+
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{TraceIdGenerator: func() string { return "fixed" }})
+	Get(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/trace",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			return commons.OkResult(ctx.Get(commons.TraceId))
+		},
+	})
+	w := doRequest(e, http.MethodGet, "/public/trace?name=x", "")
+	if !strings.Contains(w.Body.String(), `"fixed"`) {
+		t.Errorf("body %s", w.Body.String())
+	}
+}
+
+type loginProfile struct {
+	Phone string `json:"phone" log:"mask"`
+	Note  string `json:"note"`
+}
+
+type loginReq struct {
+	Name     string                   `json:"name" form:"name"`
+	Pwd      string                   `json:"pwd" form:"pwd" log:"mask"`
+	Avatar   string                   `json:"avatar" form:"avatar" log:"omit"`
+	Profile  *loginProfile            `json:"profile"`
+	Contacts []loginProfile           `json:"contacts"`
+	Extra    map[string]*loginProfile `json:"extra"`
+}
+
+func TestRedact(t *testing.T) {
+	plan := redactPlanOf(reflect.TypeOf(new(loginReq)))
+	body := `{"name":"n","pwd":"p","avatar":"a","profile":{"phone":"138","note":"x"},` +
+		`"contacts":[{"phone":"139"}],"extra":{"home":{"phone":"137"}},"token":"t","other":{"Password":"q"}}`
+	got := redactJSON([]byte(body), plan, LogMaskKeys)
+	want := `{"contacts":[{"phone":"******"}],"extra":{"home":{"phone":"******"}},"name":"n","other":{"Password":"******"},` +
+		`"profile":{"note":"x","phone":"******"},"pwd":"******","token":"******"}`
+	if got != want {
+		t.Errorf("got %s", got)
+	}
+	if got := redactJSON([]byte("not json"), plan, LogMaskKeys); got != "not json" {
+		t.Errorf("got %s", got)
+	}
+
+	u, _ := url.Parse("/login?name=n&pwd=p&avatar=a&token=t")
+	if got := redactURL(u, plan, LogMaskKeys); got != "/login?name=n&pwd=%2A%2A%2A%2A%2A%2A&token=%2A%2A%2A%2A%2A%2A" {
+		t.Errorf("got %s", got)
+	}
+	// share token可以通过query传递
+	u, _ = url.Parse("/share?id=1&stoken=s")
+	if got := redactURL(u, nil, LogMaskKeys); got != "/share?id=1&stoken=%2A%2A%2A%2A%2A%2A" {
+		t.Errorf("got %s", got)
+	}
+	u, _ = url.Parse("/login?name=n")
+	if got := redactURL(u, plan, LogMaskKeys); got != "/login?name=n" {
+		t.Errorf("got %s", got)
+	}
+
+	rt := commons.OkResult(&loginProfile{Phone: "138", Note: "x"})
+	retJson, _ := json.Marshal(rt)
+	if got := redactJSON(retJson, redactPlanOf(reflect.TypeOf(rt)), nil); !strings.Contains(got, `"phone":"******"`) {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestBodyLog(t *testing.T) {
+	conf := &bodyLogConf{maskKeys: LogMaskKeys, maxBytes: 16}
+	if got := conf.format([]byte(`{"token":"t","name":"abcdefghijklmn"}`)); got != `{"name":"abcdefg...(truncated, 37 bytes)` {
+		t.Errorf("got %s", got)
+	}
+	if got := truncateLog("中文中文", 4, 12); got != "中...(truncated, 12 bytes)" {
+		t.Errorf("got %s", got)
+	}
+	if got := conf.format([]byte{0xff, 0xfe, 0x00}); got != "<binary 3 bytes>" {
+		t.Errorf("got %s", got)
+	}
+	conf.maxBytes = -1
+	long := `{"name":"` + strings.Repeat("a", 100) + `"}`
+	if got := conf.format([]byte(long)); got != long {
+		t.Errorf("got %s", got)
+	}
+
+	// 返回结果超过限制时不再解析脱敏
+	retLog := &bodyLogConf{maskKeys: LogMaskKeys, maxBytes: 64}
+	if got := retLog.formatResult(commons.OkResult(map[string]string{"token": "t"})); got != `{"code":200,"data":{"token":"******"},"errMsg":""}` {
+		t.Errorf("got %s", got)
+	}
+	if got := retLog.formatResult(commons.OkResult(strings.Repeat("a", 100))); got != "<omitted 134 bytes>" {
+		t.Errorf("got %s", got)
+	}
+
+	if logLimit(0, 10) != 10 || logLimit(-1, 10) != -1 || logLimit(5, 10) != 5 {
+		t.Error("logLimit")
+	}
+
+	gctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("--b\r\n"))
+	gctx.Request.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	if got := conf.requestBody(gctx); got != "<multipart/form-data 5 bytes>" {
+		t.Errorf("got %s", got)
+	}
+	gctx.Request.ContentLength = -1
+	if got := conf.requestBody(gctx); got != "<multipart/form-data unknown bytes>" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var records []*AccessLogRecord
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		AccessLogFunc: func(ctx *commons.BaseContext, record *AccessLogRecord) {
+			records = append(records, record)
+		},
+		DisableRequestLog: true,
+	})
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{RelativePath: "/name/:id", BizCoreFunc: echoName})
+
+	req := httptest.NewRequest(http.MethodPost, "/public/name/3", strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(commons.TraceId, "t1")
+	req.Header.Set(commons.Platform, "ios")
+	req.Header.Set("device-id", "d1")
+	req.Header.Set("User-Agent", "ua")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	doRequest(e, http.MethodGet, "/nowhere", "")
+
+	if len(records) != 2 {
+		t.Fatalf("got %d records", len(records))
+	}
+	r := records[0]
+	if r.TraceId != "t1" || r.Route != "/public/name/:id" || r.Path != "/public/name/3" || r.Method != http.MethodPost ||
+		r.Status != http.StatusOK || r.Outcome != "ok" || r.Platform != "ios" || r.UserAgent != "ua" ||
+		r.KeyHeaders["device-id"] != "d1" || r.BytesIn != 12 || r.BytesOut != int64(w.Body.Len()) || r.LatencyMs <= 0 {
+		t.Errorf("record %+v", r)
+	}
+	if r := records[1]; r.Route != UnmatchedRoute || r.Status != http.StatusNotFound || r.TraceId == "" {
+		t.Errorf("record %+v", r)
+	}
+}
+
+func TestPanicOutsideRoute(t *testing.T) {
+	e := NewEngineWithOptions(gin.TestMode, &EngineOptions{
+		StatusMapper: HttpStatus,
+		Middlewares: []gin.HandlerFunc{func(gctx *gin.Context) {
+			if gctx.Query("name") == "middleware" {
+				panic("middleware boom")
+			}
+		}},
+		AccessLogFunc: func(ctx *commons.BaseContext, record *AccessLogRecord) {
+			if record.Path == "/public/sink" {
+				panic("sink boom")
+			}
+		},
+	})
+	Get(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{RelativePath: "/name", BizCoreFunc: echoName})
+	Get(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{RelativePath: "/sink", BizCoreFunc: echoName})
+
+	if w := doRequest(e, http.MethodGet, "/public/name?name=middleware", ""); w.Code != http.StatusInternalServerError ||
+		!strings.Contains(w.Body.String(), `"code":5000`) {
+		t.Errorf("middleware panic: %d %s", w.Code, w.Body.String())
+	}
+	// 访问日志在响应之后输出，panic时不能再写响应
+	if w := doRequest(e, http.MethodGet, "/public/sink?name=x", ""); w.Code != http.StatusOK || w.Body.String() != `{"code":200,"errMsg":"","data":"x"}` {
+		t.Errorf("sink panic: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	var info *ClientInfo
+	opts := &EngineOptions{
+		ClientHeaders: append([]ClientHeader{{Name: "channel", Log: true}}, ClientHeaders...),
+	}
+	e := NewEngineWithOptions(gin.TestMode, opts)
+	Post(e.Group("/public"), &RequestDesc[nameReq, *commons.Result[string]]{
+		RelativePath: "/client",
+		BizCoreFunc: func(ctx *commons.BaseContext, req *nameReq) *commons.Result[string] {
+			info = GetClientInfo(ctx)
+			return commons.OkResult("")
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/public/client", strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("device-id", "d1")
+	req.Header.Set("app_vsn", "1.0")
+	req.Header.Set("channel", "store")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	if info == nil || info.DeviceId != "d1" || info.AppVersion != "1.0" || info.Extra["channel"] != "store" {
+		t.Errorf("client info %+v", info)
+	}
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/public/client", nil)
+	preflight.Header.Set("Origin", "http://a.com")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	preflight.Header.Set("Access-Control-Request-Headers", "channel,device-id")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, preflight)
+	allow := strings.ToLower(w.Header().Get("Access-Control-Allow-Headers"))
+	if !strings.Contains(allow, "channel") || !strings.Contains(allow, "device-id") {
+		t.Errorf("allow headers %q", allow)
+	}
+
+	gctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gctx.Request = req
+	gctx.Set(engineOptionsName, opts)
+	if got := keysJson(gctx); got != `{"app-version":"","channel":"store","device-id":"d1","hardware":"","os":"","os-version":""}` {
+		t.Errorf("keys %s", got)
+	}
+
+	if info := GetClientInfo(commons.NewBaseContext()); info.DeviceId != "" {
+		t.Errorf("client info %+v", info)
+	}
+}
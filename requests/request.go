@@ -4,11 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/rolandhe/go-base/commons"
 	"github.com/rolandhe/go-base/logger"
-	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,13 +15,53 @@ import (
 
 type BizFunc[T any, V any] func(ctx *commons.BaseContext, req *T) V
 
+// BizFuncE 返回error的业务函数，成功时返回值包装为commons.OkResult，失败时转换为commons.Result，
+// StdError使用其错误码，其他error返回InternalError
+type BizFuncE[T any, V any] func(ctx *commons.BaseContext, req *T) (V, error)
+
+// AuthMode 路由的鉴权方式
+type AuthMode int
+
+const (
+	// AuthModeAuto 未声明鉴权方式，根据url中的/public/、/private/、/api/以及share token推断
+	AuthModeAuto AuthMode = iota
+	// AuthModePublic 公开访问，如果携带了token会尝试获取用户信息
+	AuthModePublic
+	// AuthModePrivate 内部调用，通过private-uid头获取用户信息
+	AuthModePrivate
+	// AuthModeApi 必须登录，通过token获取用户信息
+	AuthModeApi
+	// AuthModeShare 必须携带share token，在绑定请求参数后由ShareCheckFunc校验
+	AuthModeShare
+	// AuthModeNone 不做任何鉴权
+	AuthModeNone
+)
+
 type RequestDesc[T, V any] struct {
 	RelativePath  string
+	AuthMode      AuthMode
 	AllowRoles    []string
 	AllowProducts []int
 	BizCoreFunc   BizFunc[T, V]
-	LogLevel      logger.LogLevel
-	NotLogSQL     bool
+	// BizCoreFuncE 与BizCoreFunc二选一，设置时V是commons.Result中data的类型
+	BizCoreFuncE BizFuncE[T, V]
+	LogLevel     logger.LogLevel
+	NotLogSQL    bool
+	// Timeout 业务函数的最长执行时间，超时后返回TimeoutError，0表示不限制
+	Timeout time.Duration
+	// Middlewares 只作用于当前路由的gin handler，在鉴权之后、参数绑定之前执行
+	Middlewares []gin.HandlerFunc
+	// Before 在参数绑定和校验之后、BizCoreFunc之前执行，返回error时不再执行BizCoreFunc，与BizFuncE的错误一样转换
+	Before func(ctx *commons.BaseContext, req *T) error
+	// After 在BizCoreFunc之后执行，返回值替换BizCoreFunc的返回结果
+	After func(ctx *commons.BaseContext, req *T, rt V) V
+	// Consumes 请求体的content type，为空时按请求的Content-Type选择绑定方式
+	Consumes string
+	// Produces 响应的content type，为空时按请求的Accept协商，默认json
+	Produces string
+	// MaxLogRequestBytes 和 MaxLogResponseBytes 日志中请求体和响应的最大字节数，0表示使用engine的配置，小于0表示不限制
+	MaxLogRequestBytes  int
+	MaxLogResponseBytes int
 }
 
 func Get[T, V any](gg *gin.RouterGroup, rd *RequestDesc[T, V]) {
@@ -34,33 +72,72 @@ func Post[T, V any](gg *gin.RouterGroup, rd *RequestDesc[T, V]) {
 	gg.POST(rd.RelativePath, buildHandlersChain(rd)...)
 }
 
+func Put[T, V any](gg *gin.RouterGroup, rd *RequestDesc[T, V]) {
+	gg.PUT(rd.RelativePath, buildHandlersChain(rd)...)
+}
+
+func Delete[T, V any](gg *gin.RouterGroup, rd *RequestDesc[T, V]) {
+	gg.DELETE(rd.RelativePath, buildHandlersChain(rd)...)
+}
+
+func Patch[T, V any](gg *gin.RouterGroup, rd *RequestDesc[T, V]) {
+	gg.PATCH(rd.RelativePath, buildHandlersChain(rd)...)
+}
+
+func Head[T, V any](gg *gin.RouterGroup, rd *RequestDesc[T, V]) {
+	gg.HEAD(rd.RelativePath, buildHandlersChain(rd)...)
+}
+
+// Handle 按指定的http method注册，method需要是gin支持的合法值
+func Handle[T, V any](gg *gin.RouterGroup, httpMethod string, rd *RequestDesc[T, V]) {
+	gg.Handle(httpMethod, rd.RelativePath, buildHandlersChain(rd)...)
+}
+
 func buildHandlersChain[T any, V any](rd *RequestDesc[T, V]) gin.HandlersChain {
-	handlersChain := []gin.HandlerFunc{loginHandler(rd), doBizFunc(rd)}
+	if (rd.BizCoreFunc == nil) == (rd.BizCoreFuncE == nil) {
+		panic("请设置BizCoreFunc或BizCoreFuncE其中之一: " + rd.RelativePath)
+	}
+	handlersChain := []gin.HandlerFunc{loginHandler(rd)}
+	if len(rd.AllowRoles) > 0 || len(rd.AllowProducts) > 0 {
+		handlersChain = append(handlersChain, authorizeHandler(rd))
+	}
+	handlersChain = append(handlersChain, rd.Middlewares...)
+	handlersChain = append(handlersChain, doBizFunc(rd))
 
 	return handlersChain
 }
 
+// loginHandler 校验通过时直接返回，由gin继续执行后续handler，这样auth阶段只包含鉴权本身
 func loginHandler[T any, V any](rd *RequestDesc[T, V]) gin.HandlerFunc {
 	return func(gctx *gin.Context) {
+		if rd.Produces != "" {
+			// panic时recoverHandler按路由的格式输出
+			gctx.Set(producesName, rd.Produces)
+		}
+		defer startStage(gctx, StageAuth)()
 		ctx := genBaseContext(gctx)
+		opts := getEngineOptions(gctx)
 		url := gctx.Request.URL.Path
 
-		if err := RateLimiterFunc(ctx, url); err != nil {
+		if err := opts.rateLimiterFunc()(ctx, url); err != nil {
 			cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 			press := gctx.GetHeader("X-Press")
 			logger.WithBaseContextInfof(ctx)("Hit rate limit: %s,p=%s,cost=%d (%d) ms", url, press, cost, cost)
-			gctx.AbortWithStatusJSON(http.StatusOK, commons.QuickFromError(err))
+			abortWithResult(gctx, rd.Produces, ResultRateLimited, frameworkErrorResult(ResultRateLimited, err))
 			return
 		}
 
-		if strings.Contains(url, "/public/") {
+		switch resolveAuthMode(rd.AuthMode, url, ctx) {
+		case AuthModeNone:
+			return
+		case AuthModePublic:
 			token := commons.GetToken(ctx)
 
-			err := PublicUserInfoCheckFunc(ctx, token, gctx.Request.URL.Path, ctx.QuickInfo())
+			err := opts.publicUserInfoCheckFunc()(ctx, token, gctx.Request.URL.Path, ctx.QuickInfo())
 			if err != nil {
 				cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 				logger.WithBaseContextInfof(ctx)("get user info failed: %v,cost=%d (%d) ms", err, cost, cost)
-				gctx.AbortWithStatusJSON(http.StatusOK, commons.QuickFromError(err))
+				abortWithHookError(gctx, rd.Produces, ResultNotLogin, err)
 				return
 			}
 
@@ -68,49 +145,46 @@ func loginHandler[T any, V any](rd *RequestDesc[T, V]) gin.HandlerFunc {
 				gctx.Set("public_loss_token", "true")
 			}
 
-			gctx.Next()
 			return
-		}
-
-		if maybeShare(ctx) {
-			gctx.Next()
+		case AuthModeShare:
+			if !maybeShare(ctx) {
+				cost := time.Now().UnixMilli() - ctx.GetCreateTime()
+				logger.WithBaseContextInfof(ctx)("miss share token,cost=%d (%d) ms", cost, cost)
+				abortWithResult(gctx, rd.Produces, ResultNotLogin, frameworkErrorResult(ResultNotLogin, nil))
+				return
+			}
 			return
-		}
-
-		if strings.Contains(url, "/private/") {
+		case AuthModePrivate:
 			sUid := ctx.Get(commons.PrivateUid)
 			if sUid != "" {
 				uid, err := strconv.ParseInt(sUid, 10, 64)
 				if err != nil {
 					cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 					logger.WithBaseContextInfof(ctx)("parse private uid failed: %v,cost=%d (%d) ms", err, cost, cost)
-					gctx.AbortWithStatusJSON(http.StatusOK, commons.QuickFromError(err))
+					abortWithResult(gctx, rd.Produces, ResultNotLogin, frameworkErrorResult(ResultNotLogin, nil))
 					return
 				}
-				if err = PrivateUserInfoCheckFunc(ctx, uid, ctx.QuickInfo()); err != nil {
+				if err = opts.privateUserInfoCheckFunc()(ctx, uid, ctx.QuickInfo()); err != nil {
 					cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 					logger.WithBaseContextInfof(ctx)("get private user info failed: %v,cost=%d (%d) ms", err, cost, cost)
-					gctx.AbortWithStatusJSON(http.StatusOK, commons.QuickFromError(err))
+					abortWithHookError(gctx, rd.Produces, ResultNotLogin, err)
 					return
 				}
 				if ctx.QuickInfo().Uid == 0 {
 					cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 					logger.WithBaseContextInfof(ctx)("not login in,cost=%d (%d) ms", cost, cost)
-					gctx.AbortWithStatusJSON(http.StatusOK, NotLoginError)
+					abortWithResult(gctx, rd.Produces, ResultNotLogin, frameworkErrorResult(ResultNotLogin, nil))
 					return
 				}
 			}
-			gctx.Next()
 			return
-		}
-
-		if strings.Contains(url, "/api/") {
+		case AuthModeApi:
 			token := commons.GetToken(ctx)
-			err := ApiUserInfoCheckFunc(ctx, token, gctx.Request.URL.Path, ctx.QuickInfo())
+			err := opts.apiUserInfoCheckFunc()(ctx, token, gctx.Request.URL.Path, ctx.QuickInfo())
 			if err != nil {
 				cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 				logger.WithBaseContextInfof(ctx)("get user info failed: %v,cost=%d (%d) ms", err, cost, cost)
-				gctx.AbortWithStatusJSON(http.StatusOK, commons.QuickFromError(err))
+				abortWithHookError(gctx, rd.Produces, ResultNotLogin, err)
 				return
 			}
 		}
@@ -118,12 +192,55 @@ func loginHandler[T any, V any](rd *RequestDesc[T, V]) gin.HandlerFunc {
 		if ctx.QuickInfo().Uid == 0 {
 			cost := time.Now().UnixMilli() - ctx.GetCreateTime()
 			logger.WithBaseContextInfof(ctx)("not login in,cost=%d (%d) ms", cost, cost)
-			gctx.AbortWithStatusJSON(http.StatusOK, NotLoginError)
+			abortWithResult(gctx, rd.Produces, ResultNotLogin, frameworkErrorResult(ResultNotLogin, nil))
 			return
 		}
+	}
+}
 
-		gctx.Next()
+// authorizeHandler 在登录校验之后执行，校验用户的角色和产品是否在RequestDesc允许的范围内
+func authorizeHandler[T any, V any](rd *RequestDesc[T, V]) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		defer startStage(gctx, StageAuthorize)()
+		ctx := genBaseContext(gctx)
+		info := ctx.QuickInfo()
+		if err := getEngineOptions(gctx).roleCheckFunc()(ctx, rd.AllowRoles, rd.AllowProducts, info); err != nil {
+			cost := time.Now().UnixMilli() - ctx.GetCreateTime()
+			logger.WithBaseContextInfof(ctx)("forbidden: %s,uid=%d,roles=%d,allowRoles=%v,allowProducts=%v,err=%v,cost=%d (%d) ms",
+				gctx.Request.URL.Path, info.Uid, info.Roles, rd.AllowRoles, rd.AllowProducts, err, cost, cost)
+			abortWithHookError(gctx, rd.Produces, ResultForbidden, err)
+			return
+		}
+	}
+}
+
+// resolveAuthMode 返回路由实际生效的鉴权方式，未声明时按url推断，
+// 推断不出时返回AuthModeAuto，此时只要求已经获取到用户信息
+func resolveAuthMode(mode AuthMode, url string, ctx *commons.BaseContext) AuthMode {
+	if mode != AuthModeAuto {
+		return mode
+	}
+	if strings.Contains(url, "/public/") {
+		return AuthModePublic
+	}
+	if maybeShare(ctx) {
+		return AuthModeShare
+	}
+	if strings.Contains(url, "/private/") {
+		return AuthModePrivate
+	}
+	if strings.Contains(url, "/api/") {
+		return AuthModeApi
+	}
+	return AuthModeAuto
+}
+
+// needShareCheck 声明了AuthModeShare的路由必须校验share token，未声明的路由携带了share token就校验
+func needShareCheck(mode AuthMode, ctx *commons.BaseContext) bool {
+	if mode == AuthModeAuto {
+		return maybeShare(ctx)
 	}
+	return mode == AuthModeShare
 }
 
 func maybeShare(ctx *commons.BaseContext) bool {
@@ -132,74 +249,76 @@ func maybeShare(ctx *commons.BaseContext) bool {
 }
 
 func doBizFunc[T any, V any](rd *RequestDesc[T, V]) gin.HandlerFunc {
+	reqType := reflect.TypeOf(new(T))
+	pt := newParamTags(reqType)
+	errMeta := newErrMsgMeta(reqType)
+	reqPlan := redactPlanOf(reqType)
+	initTranslators()
 	return func(gctx *gin.Context) {
+		markBizStart(gctx)
 		startUnixTs := time.Now().UnixMilli()
 
 		ctx := genBaseContext(gctx)
+		opts := getEngineOptions(gctx)
 
 		ctx.QuickInfo().NotLogSqlConf = rd.NotLogSQL
 
 		var rt any
+		var kind ResultKind
 		reqObj := new(T)
 
-		var bindFunc func(obj any) error
-
-		// GET方法支持直接的query string，也支持form data,但x-www-form-urlencoded有问题
-		if gctx.Request.Method == "GET" {
-			bindFunc = gctx.ShouldBindQuery
-		} else {
-			bindFunc = func(obj any) error {
-				return gctx.ShouldBindBodyWith(obj, binding.JSON)
-			}
-		}
+		bindFunc, bodyTag := chooseBindFunc(gctx, rd.Consumes, pt)
 
-		llevel := RequestLevelFunc(ctx, gctx.Request.URL.Path, rd.LogLevel)
+		llevel := opts.requestLevelFunc()(ctx, gctx.Request.URL.Path, rd.LogLevel)
+		reqLog := &bodyLogConf{plan: reqPlan, maskKeys: opts.logMaskKeys(), maxBytes: logLimit(rd.MaxLogRequestBytes, opts.maxLogRequestBytes())}
 
 		if err := bindFunc(reqObj); err != nil {
-			beforeLog(gctx, ctx, llevel)
+			beforeLog(gctx, ctx, llevel, reqLog)
 			var errs validator.ValidationErrors
 			if ok := errors.As(err, &errs); ok {
 				logger.WithBaseContextInfof(ctx)("valid error")
-				customErrMsgs := getCustomErrMsgs(reqObj)
-				var errMsgs []string
-				for _, e := range errs {
-					ns := e.Namespace()
-					customErrMsg, ok2 := customErrMsgs[ns]
-					if ok2 {
-						errMsgs = append(errMsgs, customErrMsg)
-					} else {
-						errMsgs = append(errMsgs, e.Error())
-					}
-				}
-
-				msg := strings.Join(errMsgs, "\n")
-
-				if msg != "" {
-					rt = commons.QuickErrResult(msg)
-				}
+				rt = validationErrorResult(opts.validationErrorMode(), opts.localeFunc()(ctx), errMeta, errs, bodyTag)
 			} else {
 				logger.WithBaseContextInfof(ctx)("bind request object error: %v", err)
 			}
 			if rt == nil {
-				rt = commons.QuickErrResult("args invalid")
+				rt = commons.QuickFromError(argsInvalidError(opts.validationErrorMode()))
 			}
+			kind = ResultArgsInvalid
+		} else if err = validateRequest(ctx, reqObj); err != nil {
+			beforeLog(gctx, ctx, llevel, reqLog)
+			logger.WithBaseContextInfof(ctx)("validate request error: %v", err)
+			rt = validateErrorResult(opts.validationErrorMode(), err)
+			kind = ResultArgsInvalid
 		} else {
-			beforeLog(gctx, ctx, llevel)
-			if maybeShare(ctx) {
-				if err = ShareCheckFunc(ctx, reqObj, gctx.Request.URL.Path, ctx.QuickInfo()); err != nil {
+			beforeLog(gctx, ctx, llevel, reqLog)
+			if needShareCheck(rd.AuthMode, ctx) {
+				if err = opts.shareCheckFunc()(ctx, reqObj, gctx.Request.URL.Path, ctx.QuickInfo()); err != nil {
 					logger.WithBaseContextInfof(ctx)("check share token: %v", err)
-					gctx.AbortWithStatusJSON(http.StatusOK, commons.QuickFromError(err))
+					abortWithHookError(gctx, rd.Produces, ResultNotLogin, err)
 					return
 				}
 			}
-			rt = execBiz(ctx, gctx.Request.URL.Path, func() any {
-				return rd.BizCoreFunc(ctx, reqObj)
+			var bizErr error
+			rt, kind, bizErr = inStage(gctx, StageBiz, func() (any, ResultKind, error) {
+				return execBiz(ctx, opts, gctx.Request.URL.Path, func(release func()) (any, ResultKind, error) {
+					return callBiz(gctx, ctx, rd.Timeout, release, func(bizCtx *commons.BaseContext) (any, error) {
+						return rd.invoke(bizCtx, reqObj)
+					})
+				})
 			})
+			if bizErr != nil {
+				_ = gctx.Error(bizErr)
+			}
 		}
 
+		markBizEnd(gctx)
 		press := gctx.GetHeader("X-Press")
 
-		afterLog(ctx, press, rt, startUnixTs, llevel)
+		if !opts.requestLogDisabled() {
+			retLog := &bodyLogConf{plan: redactPlanOf(reflect.TypeOf(rt)), maskKeys: opts.logMaskKeys(), maxBytes: logLimit(rd.MaxLogResponseBytes, opts.maxLogResponseBytes())}
+			afterLog(ctx, press, rt, startUnixTs, llevel, interruptedState(gctx, kind), retLog)
+		}
 
 		if !gctx.Writer.Written() {
 			v, existed := gctx.Get("public_loss_token")
@@ -207,31 +326,63 @@ func doBizFunc[T any, V any](rd *RequestDesc[T, V]) gin.HandlerFunc {
 				gctx.Header("X-Loss-Token", "true")
 			}
 
-			gctx.JSON(http.StatusOK, rt)
+			writeResult(gctx, rd.Produces, kind, rt)
 		}
 
 		gctx.Next()
 	}
 }
 
-func execBiz(bc *commons.BaseContext, uPath string, coreFunc func() any) any {
-	err, cancelFunc := ConcurrentLimiterFunc(bc, uPath)
-	if cancelFunc != nil {
-		defer cancelFunc()
+// invoke 依次执行Before、BizCoreFunc(或BizCoreFuncE)和After，返回的error是Before或BizCoreFuncE的错误
+func (rd *RequestDesc[T, V]) invoke(ctx *commons.BaseContext, req *T) (any, error) {
+	if rd.Before != nil {
+		if err := rd.Before(ctx, req); err != nil {
+			logger.WithBaseContextInfof(ctx)("before biz error: %v", err)
+			return bizErrorResult(err), err
+		}
+	}
+	if rd.BizCoreFuncE != nil {
+		v, err := rd.BizCoreFuncE(ctx, req)
+		if err != nil {
+			logger.WithBaseContextInfof(ctx)("biz error: %v", err)
+			return bizErrorResult(err), err
+		}
+		if rd.After != nil {
+			v = rd.After(ctx, req, v)
+		}
+		return commons.OkResult(v), nil
+	}
+	rt := rd.BizCoreFunc(ctx, req)
+	if rd.After != nil {
+		rt = rd.After(ctx, req, rt)
+	}
+	return rt, nil
+}
+
+// execBiz 通过ConcurrentLimiterFunc获取并发名额后执行coreFunc，名额由coreFunc在业务函数真正结束时通过release归还
+func execBiz(bc *commons.BaseContext, opts *EngineOptions, uPath string, coreFunc func(release func()) (any, ResultKind, error)) (any, ResultKind, error) {
+	err, cancelFunc := opts.concurrentLimiterFunc()(bc, uPath)
+	if cancelFunc == nil {
+		cancelFunc = func() {}
 	}
 	if err != nil {
-		return commons.QuickFromError(err)
+		cancelFunc()
+		return frameworkErrorResult(ResultRateLimited, err), ResultRateLimited, nil
 	}
-	return coreFunc()
+	return coreFunc(cancelFunc)
 }
 
-func afterLog(baseCtx *commons.BaseContext, press string, rt any, startUnixTs int64, ll logger.LogLevel) {
+// afterLog interrupted不为空时表示请求超时或客户端已断开，与错误结果一样总是打印
+func afterLog(baseCtx *commons.BaseContext, press string, rt any, startUnixTs int64, ll logger.LogLevel, interrupted string, retLog *bodyLogConf) {
 	cr, ok := rt.(commons.CodedResult)
 	if ok {
 		if cr.GetCode() != commons.OKCode {
 			ll = logger.LOG_LEVEL_RETURN
 		}
 	}
+	if interrupted != "" {
+		ll = logger.LOG_LEVEL_RETURN
+	}
 	if ll == logger.LOG_LEVEL_NONE {
 		return
 	}
@@ -242,85 +393,36 @@ func afterLog(baseCtx *commons.BaseContext, press string, rt any, startUnixTs in
 
 	uid := baseCtx.QuickInfo().Uid
 
+	exit := "exit"
+	if interrupted != "" {
+		exit = "exit(" + interrupted + ")"
+	}
+
 	if ll&logger.LOG_LEVEL_RETURN == logger.LOG_LEVEL_RETURN {
-		retJson, _ := json.Marshal(rt)
-		logger.WithBaseContextInfof(baseCtx)("exit,uid=%d,p=%s,ret is %s,cost=%d (%d) ms", uid, press, string(retJson), latency, bizCost)
+		ret := retLog.formatResult(rt)
+		logger.WithBaseContextInfof(baseCtx)("%s,uid=%d,p=%s,ret is %s,cost=%d (%d) ms", exit, uid, press, ret, latency, bizCost)
 		return
 	}
-	logger.WithBaseContextInfof(baseCtx)("exit,uid=%d,p=%s,cost=%d (%d) ms", uid, press, latency, bizCost)
+	logger.WithBaseContextInfof(baseCtx)("%s,uid=%d,p=%s,cost=%d (%d) ms", exit, uid, press, latency, bizCost)
 }
 
-func beforeLog(gctx *gin.Context, baseCtx *commons.BaseContext, level logger.LogLevel) {
-	if level == logger.LOG_LEVEL_NONE {
+// beforeLog 记录请求，query和body按reqLog脱敏，body超过限制时截断
+func beforeLog(gctx *gin.Context, baseCtx *commons.BaseContext, level logger.LogLevel, reqLog *bodyLogConf) {
+	if level == logger.LOG_LEVEL_NONE || getEngineOptions(gctx).requestLogDisabled() {
 		return
 	}
 	uid := baseCtx.QuickInfo().Uid
+	u := redactURL(gctx.Request.URL, reqLog.plan, reqLog.maskKeys)
 	if level&logger.LOG_LEVEL_PARAM == logger.LOG_LEVEL_PARAM {
-		bodyBytes, exists := gctx.Get(gin.BodyBytesKey)
-		body := ""
-		if exists {
-			body = string(bodyBytes.([]byte))
-		}
+		body := reqLog.requestBody(gctx)
 		keysContent := keysJson(gctx)
-		logger.WithBaseContextInfof(baseCtx)("enter %s,uid=%d,keyHeader=%s,body is %s", gctx.Request.URL.String(), uid, keysContent, body)
+		logger.WithBaseContextInfof(baseCtx)("enter %s,uid=%d,keyHeader=%s,body is %s", u, uid, keysContent, body)
 		return
 	}
-	logger.WithBaseContextInfof(baseCtx)("enter %s,uid=%d", gctx.Request.URL.String(), uid)
-}
-
-func getCustomErrMsgs(req any) map[string]string {
-	reqType := reflect.TypeOf(req)
-	if reqType.Kind() != reflect.Ptr || reqType.Elem().Kind() != reflect.Struct {
-		return map[string]string{}
-	}
-
-	errMsgs := map[string]string{}
-	findCustomErrMsgs(reqType.Elem(), reqType.Elem().Name(), "", errMsgs)
-	return errMsgs
-}
-
-func findCustomErrMsgs(tType reflect.Type, tName string, tPath string, errMsgs map[string]string) {
-	var sType reflect.Type
-	sTypeKind := tType.Kind()
-	if sTypeKind == reflect.Ptr {
-		sType = tType.Elem()
-	} else {
-		sType = tType
-	}
-
-	tPath = tPath + tName + "."
-
-	for i := 0; i < sType.NumField(); i++ {
-		f := sType.Field(i)
-		fType := f.Type
-		fName := f.Name
-
-		errMsg := f.Tag.Get("errMsg")
-		if errMsg != "" {
-			ns := tPath + fName
-			errMsgs[ns] = errMsg
-		}
-
-		if fType.Kind() == reflect.Ptr && fType.Elem().Kind() == reflect.Struct {
-			findCustomErrMsgs(fType, fName, tPath, errMsgs)
-		}
-	}
-}
-
-var keyHeaders = []string{
-	"device-id",
-	"hardware",
-	"os",
-	"os-version",
-	"app-version",
+	logger.WithBaseContextInfof(baseCtx)("enter %s,uid=%d", u, uid)
 }
 
 func keysJson(gctx *gin.Context) string {
-	km := map[string]string{}
-	for _, k := range keyHeaders {
-		v := getHeader(gctx, k)
-		km[k] = v
-	}
-	j, _ := json.Marshal(km)
+	j, _ := json.Marshal(keyHeaderValues(gctx))
 	return string(j)
 }
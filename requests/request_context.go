@@ -0,0 +1,104 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"github.com/rolandhe/go-base/logger"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	requestContextName = "request_context_qweb"
+)
+
+// requestContextHolder 保存业务函数使用的context.Context，未设置时使用当前请求的context
+type requestContextHolder struct {
+	gctx *gin.Context
+	ctx  context.Context
+}
+
+func (h *requestContextHolder) get() context.Context {
+	if h.ctx != nil {
+		return h.ctx
+	}
+	return h.gctx.Request.Context()
+}
+
+// RequestContext 获取请求对应的context.Context，客户端断开或超过RequestDesc.Timeout时被取消，
+// 不是通过本包创建的BaseContext返回context.Background()
+func RequestContext(ctx *commons.BaseContext) context.Context {
+	if c, ok := ctx.GetExtendValue(requestContextName).(context.Context); ok {
+		return c
+	}
+	return context.Background()
+}
+
+func setRequestContext(gctx *gin.Context, c context.Context) {
+	v, exists := gctx.Get(requestContextName)
+	if !exists {
+		return
+	}
+	v.(*requestContextHolder).ctx = c
+}
+
+type bizOutcome struct {
+	rt  any
+	err error
+}
+
+// callBiz 执行业务函数，timeout大于0时在新的goroutine中执行，超时或客户端断开时不再等待，直接返回对应的框架错误，
+// 业务函数需要通过RequestContext感知取消并尽快退出。release在业务函数结束时调用，超时返回后仍然占用并发名额。
+// 新的goroutine中业务函数使用ctx的副本，超时返回后框架继续读取ctx记录日志，不能与业务函数并发读写
+func callBiz(gctx *gin.Context, ctx *commons.BaseContext, timeout time.Duration, release func(), coreFunc func(ctx *commons.BaseContext) (any, error)) (any, ResultKind, error) {
+	if timeout <= 0 {
+		defer release()
+		rt, err := coreFunc(ctx)
+		return rt, resultKindOf(rt), err
+	}
+
+	c, cancel := context.WithTimeout(gctx.Request.Context(), timeout)
+	defer cancel()
+	setRequestContext(gctx, c)
+
+	done := make(chan *bizOutcome, 1)
+	panicked := make(chan any, 1)
+	bizCtx := ctx.Clone()
+	go func() {
+		defer release()
+		defer func() {
+			if r := recover(); r != nil {
+				logger.WithBaseContextErrorf(bizCtx)("panic stack: %s", string(debug.Stack()))
+				panicked <- r
+			}
+		}()
+		rt, err := coreFunc(bizCtx)
+		done <- &bizOutcome{rt: rt, err: err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.rt, resultKindOf(o.rt), o.err
+	case r := <-panicked:
+		// 交给recoverHandler统一处理
+		panic(r)
+	case <-c.Done():
+		if errors.Is(c.Err(), context.DeadlineExceeded) {
+			return frameworkErrorResult(ResultTimeout, nil), ResultTimeout, TimeoutError
+		}
+		return frameworkErrorResult(ResultCanceled, nil), ResultCanceled, CanceledError
+	}
+}
+
+// interruptedState 请求被中断的原因，用于日志，没有中断时返回空串
+func interruptedState(gctx *gin.Context, kind ResultKind) string {
+	if kind == ResultTimeout {
+		return "timeout"
+	}
+	if kind == ResultCanceled || errors.Is(gctx.Request.Context().Err(), context.Canceled) {
+		return "client canceled"
+	}
+	return ""
+}
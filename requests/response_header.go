@@ -0,0 +1,111 @@
+package requests
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"strings"
+	"time"
+)
+
+const ServerTimingHeader = "Server-Timing"
+
+// TraceIdResponseHeader 返回trace id的响应头，设置为空时不返回
+var TraceIdResponseHeader = "X-Trace-Id"
+
+// ServerTimingEnabled 为true时在Server-Timing响应头中返回请求的总耗时、鉴权耗时和业务耗时
+var ServerTimingEnabled = false
+
+// responseHeaderHandler 替换gctx.Writer，在响应头写出之前补充trace id和Server-Timing，
+// 需要在所有中间件之前执行，这样跨域拒绝、健康检查和panic的响应也能带上
+func responseHeaderHandler(opts *EngineOptions) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		header := opts.traceIdResponseHeader()
+		serverTiming := opts.serverTiming()
+		if header == "" && !serverTiming {
+			return
+		}
+		w := &headerWriter{
+			ResponseWriter: gctx.Writer,
+			gctx:           gctx,
+			start:          time.Now(),
+			traceHeader:    header,
+			serverTiming:   serverTiming,
+		}
+		gctx.Writer = w
+		gctx.Next()
+		// 只设置了状态码的响应由gin在最后直接写出，不经过gctx.Writer
+		if !w.Written() {
+			w.writeHeaders()
+		}
+	}
+}
+
+type headerWriter struct {
+	gin.ResponseWriter
+	gctx         *gin.Context
+	start        time.Time
+	traceHeader  string
+	serverTiming bool
+	done         bool
+}
+
+func (w *headerWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.writeHeaders()
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *headerWriter) Write(data []byte) (int, error) {
+	if !w.Written() {
+		w.writeHeaders()
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *headerWriter) WriteString(s string) (int, error) {
+	if !w.Written() {
+		w.writeHeaders()
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Flush 流式响应可能在第一次Write之前Flush，此时响应头已经发出
+func (w *headerWriter) Flush() {
+	if !w.Written() {
+		w.writeHeaders()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *headerWriter) writeHeaders() {
+	if w.done {
+		return
+	}
+	w.done = true
+	h := w.ResponseWriter.Header()
+	if w.traceHeader != "" {
+		h.Set(w.traceHeader, genBaseContext(w.gctx).Get(commons.TraceId))
+	}
+	if w.serverTiming {
+		h.Set(ServerTimingHeader, w.timingValue())
+	}
+}
+
+func (w *headerWriter) timingValue() string {
+	metrics := []string{formatTiming("total", time.Since(w.start))}
+	if timing := getRequestTiming(w.gctx); timing != nil {
+		if cost, ok := timing.authCost(); ok {
+			metrics = append(metrics, formatTiming(StageAuth, cost))
+		}
+		if cost, ok := timing.bizCost(); ok {
+			metrics = append(metrics, formatTiming(StageBiz, cost))
+		}
+	}
+	return strings.Join(metrics, ", ")
+}
+
+func formatTiming(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", name, toMillis(d))
+}
@@ -0,0 +1,47 @@
+package requests
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+)
+
+const (
+	traceIdName = "trace_id_qweb"
+)
+
+// 请求处理的阶段，作为StageFunc的stage参数
+const (
+	StageAuth      = "auth"
+	StageAuthorize = "authorize"
+	StageBiz       = "biz"
+)
+
+// StageFunc 在请求进入某个处理阶段时调用，返回的函数在阶段结束时调用，用于接入链路追踪等。
+// 实现可以替换gctx.Request的context，业务函数通过RequestContext获取到的就是替换后的context
+type StageFunc func(gctx *gin.Context, stage string) func()
+
+// RequestStageFunc 默认为nil，不做任何处理
+var RequestStageFunc StageFunc
+
+func startStage(gctx *gin.Context, stage string) func() {
+	f := getEngineOptions(gctx).stageFunc()
+	if f == nil {
+		return func() {}
+	}
+	return f(gctx, stage)
+}
+
+// inStage 在stage阶段中执行f，f panic时阶段同样结束
+func inStage(gctx *gin.Context, stage string, f func() (any, ResultKind, error)) (any, ResultKind, error) {
+	defer startStage(gctx, stage)()
+	return f()
+}
+
+// SetTraceId 设置当前请求的trace id，用于和链路追踪系统的trace id保持一致，
+// 需要在路由handler之前的中间件中调用，已经创建的BaseContext也会同步修改
+func SetTraceId(gctx *gin.Context, traceId string) {
+	gctx.Set(traceIdName, traceId)
+	if v, exists := gctx.Get(baseContextName); exists {
+		v.(*commons.BaseContext).Put(commons.TraceId, traceId)
+	}
+}
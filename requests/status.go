@@ -0,0 +1,146 @@
+package requests
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rolandhe/go-base/commons"
+	"net/http"
+)
+
+const (
+	resultKindName = "result_kind_qweb"
+	resultCodeName = "result_code_qweb"
+)
+
+// ResultKind 请求的处理结果类别，用于选择http状态码
+type ResultKind int
+
+const (
+	ResultOK ResultKind = iota
+	// ResultBizError 业务返回了非OK的错误码
+	ResultBizError
+	ResultNotLogin
+	ResultForbidden
+	// ResultRateLimited 被RateLimiterFunc或ConcurrentLimiterFunc拒绝
+	ResultRateLimited
+	ResultArgsInvalid
+	ResultPanic
+	// ResultTimeout 业务函数超过RequestDesc.Timeout没有返回
+	ResultTimeout
+	// ResultCanceled 业务函数返回前客户端断开了连接
+	ResultCanceled
+)
+
+// Outcome 结果类别归并后的名称，用作监控的标签
+func (k ResultKind) Outcome() string {
+	switch k {
+	case ResultOK:
+		return "ok"
+	case ResultBizError, ResultArgsInvalid:
+		return "biz_error"
+	case ResultNotLogin, ResultForbidden:
+		return "auth_error"
+	case ResultRateLimited:
+		return "rate_limited"
+	case ResultPanic:
+		return "panic"
+	case ResultTimeout:
+		return "timeout"
+	case ResultCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
+// StatusMapper 根据结果类别和返回给调用方的结果选择http状态码，返回结果的格式不受影响
+type StatusMapper func(kind ResultKind, rt any) int
+
+// ResultStatusMapper 所有engine默认的状态码映射，可以通过EngineOptions按engine覆盖
+var ResultStatusMapper StatusMapper = AlwaysOKStatus
+
+// AlwaysOKStatus 所有结果都返回200，错误只体现在返回结果的code中
+func AlwaysOKStatus(kind ResultKind, rt any) int {
+	return http.StatusOK
+}
+
+// HttpStatus 未登录返回401，无权限403，限流429，参数错误400，panic 500，超时504，客户端断开499，
+// 业务错误按返回结果中的commons错误码映射，其他返回200
+func HttpStatus(kind ResultKind, rt any) int {
+	switch kind {
+	case ResultNotLogin:
+		return http.StatusUnauthorized
+	case ResultForbidden:
+		return http.StatusForbidden
+	case ResultRateLimited:
+		return http.StatusTooManyRequests
+	case ResultArgsInvalid:
+		return http.StatusBadRequest
+	case ResultPanic:
+		return http.StatusInternalServerError
+	case ResultTimeout:
+		return http.StatusGatewayTimeout
+	case ResultCanceled:
+		// 与nginx一致，表示客户端关闭了连接
+		return 499
+	case ResultBizError:
+		cr, ok := rt.(commons.CodedResult)
+		if !ok {
+			return http.StatusOK
+		}
+		switch cr.GetCode() {
+		case errorCode(NotLoginError), commons.NotValidUser, commons.InvalidIdentify:
+			return http.StatusUnauthorized
+		case errorCode(ForbiddenError):
+			return http.StatusForbidden
+		case errorCode(RateLimitedError):
+			return http.StatusTooManyRequests
+		case errorCode(ArgsInvalidError):
+			return http.StatusBadRequest
+		}
+	}
+	return http.StatusOK
+}
+
+// resultKindOf 业务函数返回结果的类别，实现了commons.CodedResult且错误码不是OK的属于业务错误
+func resultKindOf(rt any) ResultKind {
+	if cr, ok := rt.(commons.CodedResult); ok && cr.GetCode() != commons.OKCode {
+		return ResultBizError
+	}
+	return ResultOK
+}
+
+// markResult 记录结果类别和返回结果中的错误码，供监控使用
+func markResult(gctx *gin.Context, kind ResultKind, rt any) {
+	gctx.Set(resultKindName, kind)
+	if cr, ok := rt.(commons.CodedResult); ok {
+		gctx.Set(resultCodeName, cr.GetCode())
+	}
+}
+
+// RequestOutcome 获取请求的结果类别和错误码，在路由handler之后读取，没有经过writeResult的请求(如跨域拒绝、404)按gctx.Errors判断，错误码为0
+func RequestOutcome(gctx *gin.Context) (ResultKind, int) {
+	kind := ResultOK
+	if v, exists := gctx.Get(resultKindName); exists {
+		kind = v.(ResultKind)
+	}
+	if kind == ResultOK && len(gctx.Errors) > 0 {
+		kind = ResultBizError
+	}
+	return kind, gctx.GetInt(resultCodeName)
+}
+
+// writeResult 按engine的StatusMapper选择状态码输出结果，并记录结果类别
+func writeResult(gctx *gin.Context, produces string, kind ResultKind, rt any) {
+	markResult(gctx, kind, rt)
+	renderResult(gctx, produces, getEngineOptions(gctx).statusMapper()(kind, rt), rt)
+}
+
+func abortWithResult(gctx *gin.Context, produces string, kind ResultKind, rt any) {
+	gctx.Abort()
+	writeResult(gctx, produces, kind, rt)
+}
+
+// abortWithHookError 鉴权钩子返回err时中止请求，StdError按其错误码返回，其他error返回InternalError
+func abortWithHookError(gctx *gin.Context, produces string, kind ResultKind, err error) {
+	kind = hookErrorKind(kind, err)
+	abortWithResult(gctx, produces, kind, frameworkErrorResult(kind, err))
+}
@@ -0,0 +1,181 @@
+package requests
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rolandhe/go-base/commons"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 常见的上游trace id请求头
+const (
+	RequestIdHeader   = "X-Request-Id"
+	TraceParentHeader = "traceparent"
+	B3TraceIdHeader   = "X-B3-TraceId"
+)
+
+// MaxTraceIdLength 上游传入的trace id的最大长度，超过的丢弃并重新生成
+const MaxTraceIdLength = 128
+
+// TraceIdHeaders 按顺序查找上游传入的trace id，取第一个合法的值，traceparent取其中的trace-id部分
+var TraceIdHeaders = []string{
+	commons.TraceId,
+	RequestIdHeader,
+	TraceParentHeader,
+	B3TraceIdHeader,
+}
+
+// TraceIdGenerator 上游没有传入合法的trace id时用于生成trace id
+type TraceIdGenerator func() string
+
+// NewTraceIdFunc 默认生成去掉"-"的uuid加"-cr"后缀
+var NewTraceIdFunc TraceIdGenerator = UUIDTraceId
+
+// inboundTraceId 按headers的顺序获取上游传入的trace id，不合法的值被忽略并通过invalid返回，用于记录日志
+func inboundTraceId(gctx *gin.Context, headers []string) (tid string, invalid []string) {
+	for _, h := range headers {
+		v := getHeader(gctx, h)
+		if v == "" {
+			continue
+		}
+		var ok bool
+		switch {
+		case strings.EqualFold(h, TraceParentHeader):
+			v, ok = parseTraceParent(v)
+		case strings.EqualFold(h, B3TraceIdHeader):
+			ok = isHex(v) && (len(v) == 16 || len(v) == 32) && !allZero(v)
+		default:
+			ok = validTraceId(v)
+		}
+		if ok {
+			return v, invalid
+		}
+		invalid = append(invalid, h)
+	}
+	return "", invalid
+}
+
+// validTraceId 只允许字母、数字和"-_.:"，防止在日志中注入换行等字符
+func validTraceId(v string) bool {
+	if len(v) > MaxTraceIdLength {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			continue
+		}
+		if c == '-' || c == '_' || c == '.' || c == ':' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// parseTraceParent 解析W3C traceparent: version-traceid-parentid-flags，返回其中的trace id
+func parseTraceParent(v string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", false
+	}
+	for _, p := range parts[:4] {
+		if !isHex(p) || p != strings.ToLower(p) {
+			return "", false
+		}
+	}
+	if allZero(parts[1]) || allZero(parts[2]) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func isHex(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return v != ""
+}
+
+func allZero(v string) bool {
+	return strings.Trim(v, "0") == ""
+}
+
+// UUIDTraceId 去掉"-"的随机uuid加"-cr"后缀
+func UUIDTraceId() string {
+	raw := uuid.NewString()
+	return strings.ReplaceAll(raw, "-", "") + "-cr"
+}
+
+// UUIDv7TraceId 去掉"-"的uuid v7，按时间有序
+func UUIDv7TraceId() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return UUIDTraceId()
+	}
+	return strings.ReplaceAll(id.String(), "-", "")
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDTraceId 26位的ULID，48位毫秒时间戳加80位随机数，使用Crockford base32编码
+func ULIDTraceId() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	_, _ = rand.Read(b[6:])
+
+	// 128位从高到低每5位一个字符，最高的字符只有3位
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// snowflake时间戳的起点，2024-01-01 00:00:00 UTC
+const snowflakeEpoch = 1704067200000
+
+// NewSnowflakeTraceId 返回snowflake算法的生成器，41位毫秒时间戳、10位节点号、12位序号，node取低10位
+func NewSnowflakeTraceId(node int64) TraceIdGenerator {
+	var (
+		mu     sync.Mutex
+		lastTs int64
+		seq    int64
+	)
+	node &= 0x3ff
+	return func() string {
+		mu.Lock()
+		ts := time.Now().UnixMilli()
+		if ts < lastTs {
+			// 时钟回拨时沿用上一次的时间戳
+			ts = lastTs
+		}
+		if ts == lastTs {
+			seq = (seq + 1) & 0xfff
+			if seq == 0 {
+				ts++
+			}
+		} else {
+			seq = 0
+		}
+		lastTs = ts
+		id := (ts-snowflakeEpoch)<<22 | node<<12 | seq
+		mu.Unlock()
+		return strconv.FormatInt(id, 10)
+	}
+}
@@ -0,0 +1,456 @@
+package requests
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
+	zhtranslations "github.com/go-playground/validator/v10/translations/zh"
+	"github.com/rolandhe/go-base/commons"
+	"github.com/rolandhe/go-base/logger"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ValidationErrorMode 参数校验失败时返回的错误格式
+type ValidationErrorMode int
+
+const (
+	// ValidationErrorText 所有错误信息用换行拼接成一个字符串，放在errMsg中返回
+	ValidationErrorText ValidationErrorMode = iota + 1
+	// ValidationErrorStructured 在errMsg之外，通过data返回每个校验失败字段的FieldError列表
+	ValidationErrorStructured
+)
+
+// ValidationErrorOutput 所有engine默认的校验错误格式，可以通过EngineOptions按engine覆盖
+var ValidationErrorOutput = ValidationErrorText
+
+// LocaleFunc 获取校验错误信息使用的语言，如zh、en，返回空串或不支持的语言时使用validator的原始错误信息。
+// 默认取Accept-Language中的第一个语言，可以替换为按platform等请求头判断
+var LocaleFunc = func(ctx *commons.BaseContext) string {
+	return parseAcceptLanguage(ctx.GetExtendStringValue("Accept-Language"))
+}
+
+var (
+	translatorOnce sync.Once
+	translator     *ut.UniversalTranslator
+)
+
+// initTranslators 在gin使用的validator上注册中英文的默认错误信息，在注册路由时调用，保证处理请求前已完成注册
+func initTranslators() {
+	translatorOnce.Do(func() {
+		enLocale := en.New()
+		translator = ut.New(enLocale, enLocale, zh.New())
+
+		v, err := ginValidator()
+		if err != nil {
+			return
+		}
+		enTrans, _ := translator.GetTranslator("en")
+		if err := entranslations.RegisterDefaultTranslations(v, enTrans); err != nil {
+			logger.Errorf("register en validation translations failed: %v", err)
+		}
+		zhTrans, _ := translator.GetTranslator("zh")
+		if err := zhtranslations.RegisterDefaultTranslations(v, zhTrans); err != nil {
+			logger.Errorf("register zh validation translations failed: %v", err)
+		}
+	})
+}
+
+// findTranslator 查找locale对应的翻译器，没有注册过翻译时返回false
+func findTranslator(locale string) (ut.Translator, bool) {
+	if locale == "" || translator == nil {
+		return nil, false
+	}
+	return translator.FindTranslator(locale)
+}
+
+// parseAcceptLanguage 从Accept-Language(如zh-CN,zh;q=0.9,en;q=0.8)中取出第一个语言的主语言部分
+func parseAcceptLanguage(acceptLanguage string) string {
+	first, _, _ := strings.Cut(acceptLanguage, ",")
+	first, _, _ = strings.Cut(first, ";")
+	first, _, _ = strings.Cut(strings.TrimSpace(first), "-")
+	first, _, _ = strings.Cut(first, "_")
+	return strings.ToLower(first)
+}
+
+// Validatable 请求类型实现该接口时，在参数绑定和tag校验通过之后、ShareCheckFunc之前调用Validate，
+// 用于字段之间的关联校验等tag无法表达的规则。返回commons.StdError时按其错误码返回，否则把错误信息返回给调用方
+type Validatable interface {
+	Validate(ctx *commons.BaseContext) error
+}
+
+// RegisterValidation 在gin使用的validator上注册自定义的校验tag，需要在注册路由之前调用
+func RegisterValidation(tag string, fn validator.Func, callValidationEvenIfNull ...bool) error {
+	v, err := ginValidator()
+	if err != nil {
+		return err
+	}
+	return v.RegisterValidation(tag, fn, callValidationEvenIfNull...)
+}
+
+// RegisterStructValidation 在gin使用的validator上为types注册结构体级别的校验，需要在注册路由之前调用
+func RegisterStructValidation(fn validator.StructLevelFunc, types ...any) error {
+	v, err := ginValidator()
+	if err != nil {
+		return err
+	}
+	v.RegisterStructValidation(fn, types...)
+	return nil
+}
+
+// RegisterTranslation 为自定义校验tag注册locale下的错误信息，text中的{0}替换为字段名，{1}替换为tag参数
+func RegisterTranslation(tag string, locale string, text string) error {
+	v, err := ginValidator()
+	if err != nil {
+		return err
+	}
+	initTranslators()
+	trans, found := translator.GetTranslator(locale)
+	if !found {
+		return errors.New("unsupported locale: " + locale)
+	}
+	return v.RegisterTranslation(tag, trans, func(t ut.Translator) error {
+		return t.Add(tag, text, true)
+	}, func(t ut.Translator, fe validator.FieldError) string {
+		msg, err := t.T(tag, fe.Field(), fe.Param())
+		if err != nil {
+			return fe.Error()
+		}
+		return msg
+	})
+}
+
+func ginValidator() (*validator.Validate, error) {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return nil, errors.New("gin validator engine is not *validator.Validate")
+	}
+	return v, nil
+}
+
+// validateRequest 请求类型实现了Validatable时执行自定义校验
+func validateRequest(ctx *commons.BaseContext, reqObj any) error {
+	v, ok := reqObj.(Validatable)
+	if !ok {
+		return nil
+	}
+	return v.Validate(ctx)
+}
+
+func validateErrorResult(mode ValidationErrorMode, err error) any {
+	var stdErr *commons.StdError
+	if errors.As(err, &stdErr) {
+		return commons.QuickFromError(err)
+	}
+	return commons.ErrResult(errorCode(argsInvalidError(mode)), err.Error())
+}
+
+// FieldError 单个字段的校验错误
+type FieldError struct {
+	// Field 字段在请求中的名称，json请求体中是路径，如items[0].name，路径参数、请求头、query和表单使用对应tag中的名称
+	Field string `json:"field"`
+	// Tag 校验失败的validator tag，如required、max
+	Tag string `json:"tag"`
+	// Param validator tag的参数，如max=10中的10
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// validationErrorResult 把validator的错误转换成返回给调用方的结果，没有可用的错误信息时返回nil。
+// 错误信息优先使用字段上locale对应的errMsg_<locale> tag，其次是errMsg tag，再次是validator翻译后的信息
+func validationErrorResult(mode ValidationErrorMode, locale string, meta *errMsgMeta, errs validator.ValidationErrors, bodyTag string) any {
+	trans, canTranslate := findTranslator(locale)
+	var errMsgs []string
+	var fieldErrors []*FieldError
+	for _, e := range errs {
+		ns := e.Namespace()
+		msg, ok := meta.lookup(ns, locale)
+		if !ok {
+			if canTranslate {
+				msg = e.Translate(trans)
+			} else {
+				msg = e.Error()
+			}
+		}
+		errMsgs = append(errMsgs, msg)
+		if mode == ValidationErrorStructured {
+			fieldErrors = append(fieldErrors, &FieldError{
+				Field:   fieldPath(meta.reqType, ns, bodyTag),
+				Tag:     e.Tag(),
+				Param:   e.Param(),
+				Message: msg,
+			})
+		}
+	}
+
+	msg := strings.Join(errMsgs, "\n")
+	if msg == "" {
+		return nil
+	}
+	if mode == ValidationErrorStructured {
+		return commons.NewResult(errorCode(ArgsInvalidError), msg, fieldErrors)
+	}
+	return commons.ErrResult(errorCode(TextArgsInvalidError), msg)
+}
+
+// errMsgMeta 请求类型上errMsg和errMsg_<locale> tag的元数据，在注册路由时按类型计算一次。
+// msgs的key是去掉根类型名和下标的Namespace，如Items[].Title，value是locale到错误信息的映射，默认的errMsg对应空串
+type errMsgMeta struct {
+	reqType reflect.Type
+	msgs    map[string]map[string]string
+}
+
+func newErrMsgMeta(reqType reflect.Type) *errMsgMeta {
+	meta := &errMsgMeta{
+		reqType: reqType,
+		msgs:    map[string]map[string]string{},
+	}
+	meta.collect(reqType, "", map[reflect.Type]int{})
+	return meta
+}
+
+// collect 遍历类型中的字段，包括匿名嵌入、值类型和指针类型的结构体，以及slice、array元素和map value中的结构体，
+// 自引用的类型只展开一层，如Children []Node中的Children[].Name，更深层级的字段使用validator的错误信息
+func (m *errMsgMeta) collect(t reflect.Type, prefix string, visiting map[reflect.Type]int) {
+	t = indirectType(t)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		m.collect(t.Elem(), prefix+"[]", visiting)
+	case reflect.Struct:
+		if visiting[t] > 1 {
+			return
+		}
+		visiting[t]++
+		defer func() { visiting[t]-- }()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			ns := f.Name
+			if prefix != "" {
+				ns = prefix + "." + f.Name
+			}
+			if localeMsgs := errMsgTags(f.Tag); len(localeMsgs) > 0 {
+				m.msgs[ns] = localeMsgs
+			}
+			m.collect(f.Type, ns, visiting)
+		}
+	}
+}
+
+// lookup 按validator的Namespace(如Req.Items[0].Title)查找自定义错误信息，locale没有单独配置时使用errMsg
+func (m *errMsgMeta) lookup(ns string, locale string) (string, bool) {
+	localeMsgs, ok := m.msgs[normalizeNamespace(ns)]
+	if !ok {
+		return "", false
+	}
+	if msg, ok := localeMsgs[locale]; ok {
+		return msg, true
+	}
+	msg, ok := localeMsgs[""]
+	return msg, ok
+}
+
+// normalizeNamespace 去掉Namespace中的根类型名和下标，Req.Items[0].Tags[k].Name转换成Items[].Tags[].Name
+func normalizeNamespace(ns string) string {
+	_, ns, _ = strings.Cut(ns, ".")
+	if !strings.Contains(ns, "[") {
+		return ns
+	}
+	b := strings.Builder{}
+	inIndex := false
+	for _, c := range ns {
+		switch {
+		case c == '[':
+			inIndex = true
+			b.WriteString("[]")
+		case c == ']':
+			inIndex = false
+		case !inIndex:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// errMsgTags 解析字段上的errMsg和errMsg_<locale> tag
+func errMsgTags(tag reflect.StructTag) map[string]string {
+	const prefix = "errMsg"
+	var localeMsgs map[string]string
+	for tag != "" {
+		// 与reflect.StructTag.Lookup的解析规则一致
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		name := string(tag[:i])
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		qvalue := string(tag[:i+1])
+		tag = tag[i+1:]
+
+		locale, isErrMsg := strings.CutPrefix(name, prefix)
+		if !isErrMsg || (locale != "" && locale[0] != '_') {
+			continue
+		}
+		value, err := strconv.Unquote(qvalue)
+		if err != nil || value == "" {
+			continue
+		}
+		if localeMsgs == nil {
+			localeMsgs = map[string]string{}
+		}
+		localeMsgs[strings.TrimPrefix(locale, "_")] = value
+	}
+	return localeMsgs
+}
+
+// fieldPath 校验错误对应的请求参数名，声明了uri或header tag的字段使用其中的名称，
+// bodyTag为form时使用form tag中的名称(gin绑定表单时嵌套结构体的字段不带前缀)，否则使用jsonPath
+func fieldPath(t reflect.Type, ns string, bodyTag string) string {
+	f, index, ok := leafField(t, ns)
+	if !ok {
+		return jsonPath(t, ns)
+	}
+	for _, tag := range []string{"uri", "header"} {
+		if name := tagName(f, tag); name != "" {
+			return name + index
+		}
+	}
+	if bodyTag == "form" {
+		name := tagName(f, "form")
+		if name == "" {
+			name = f.Name
+		}
+		return name + index
+	}
+	return jsonPath(t, ns)
+}
+
+// leafField 查找Namespace中最后一段对应的结构体字段，同时返回其中的下标，如Tags[0]中的[0]
+func leafField(t reflect.Type, ns string) (reflect.StructField, string, bool) {
+	segments := splitNamespace(ns)
+	var f reflect.StructField
+	var index string
+	for _, seg := range segments[1:] {
+		t = indirectType(t)
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+			t = indirectType(t.Elem())
+		}
+		if t.Kind() != reflect.Struct {
+			return f, "", false
+		}
+		name, idx, _ := strings.Cut(seg, "[")
+		var ok bool
+		if f, ok = t.FieldByName(name); !ok {
+			return f, "", false
+		}
+		index = ""
+		if idx != "" {
+			index = "[" + idx
+		}
+		t = f.Type
+	}
+	return f, index, len(segments) > 1
+}
+
+func tagName(f reflect.StructField, tag string) string {
+	name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// jsonPath 把validator的Namespace(如Req.Items[0].Name)转换成json中的路径(如items[0].name)，
+// 匿名嵌入的结构体在json中是展开的，不出现在路径中
+func jsonPath(t reflect.Type, ns string) string {
+	segments := splitNamespace(ns)
+	if len(segments) <= 1 {
+		return ns
+	}
+	var path []string
+	t = indirectType(t)
+	for _, seg := range segments[1:] {
+		name, index, _ := strings.Cut(seg, "[")
+		if index != "" {
+			index = "[" + index
+		}
+		if t.Kind() != reflect.Struct {
+			path = append(path, seg)
+			continue
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			path = append(path, seg)
+			continue
+		}
+		t = indirectType(f.Type)
+		jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && jsonName == "" && t.Kind() == reflect.Struct {
+			continue
+		}
+		if jsonName == "" || jsonName == "-" {
+			jsonName = f.Name
+		}
+		path = append(path, jsonName+index)
+		for i := strings.Count(index, "["); i > 0 && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map); i-- {
+			t = indirectType(t.Elem())
+		}
+	}
+	return strings.Join(path, ".")
+}
+
+// splitNamespace 按"."分割Namespace，map的key中可能包含"."，下标内的不分割
+func splitNamespace(ns string) []string {
+	var segments []string
+	depth, start := 0, 0
+	for i := 0; i < len(ns); i++ {
+		switch ns[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		case '.':
+			if depth == 0 {
+				segments = append(segments, ns[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(segments, ns[start:])
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}